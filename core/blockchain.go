@@ -102,6 +102,10 @@ const (
 	txLookupCacheLimit = 1024
 	TriesInMemory      = 128
 
+	// addressTxBackfillRange is the number of blocks indexed per step when the
+	// per-address transaction index is backfilled.
+	addressTxBackfillRange = 10000
+
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	//
 	// Changelog:
@@ -138,6 +142,7 @@ type CacheConfig struct {
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
 	SnapshotLimit       int           // Memory allowance (MB) to use for caching snapshot entries in memory
 	Preimages           bool          // Whether to store preimage of trie key to the disk
+	AddressTxIndex      bool          // Whether to maintain the per-address transaction index, blocks imported earlier are backfilled
 	StateHistory        uint64        // Number of blocks from head whose state histories are reserved.
 	StateScheme         string        // Scheme used to store ethereum states and merkle tree nodes on top
 
@@ -464,9 +469,54 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *Genesis
 	if txLookupLimit != nil {
 		bc.txIndexer = newTxIndexer(*txLookupLimit, bc)
 	}
+	// Backfill the per-address transaction index if it's enabled. Otherwise
+	// drop its tail, since blocks imported from now on are not indexed.
+	if bc.cacheConfig.AddressTxIndex {
+		if rawdb.ReadAddressTxIndexTail(bc.db) == nil {
+			head := bc.CurrentBlock().Number.Uint64()
+			if snap := bc.CurrentSnapBlock().Number.Uint64(); snap > head {
+				head = snap
+			}
+			rawdb.WriteAddressTxIndexTail(bc.db, head+1)
+		}
+		bc.wg.Add(1)
+		go bc.backfillAddressTxIndex()
+	} else if rawdb.ReadAddressTxIndexTail(bc.db) != nil {
+		rawdb.DeleteAddressTxIndexTail(bc.db)
+	}
 	return bc, nil
 }
 
+// backfillAddressTxIndex builds the per-address transaction index of the blocks
+// imported before the index was enabled, from the newest to the oldest. The
+// progress is tracked by the index tail, so an interrupted run resumes there.
+func (bc *BlockChain) backfillAddressTxIndex() {
+	defer bc.wg.Done()
+
+	tail := *rawdb.ReadAddressTxIndexTail(bc.db)
+	if tail == 0 {
+		return
+	}
+	log.Info("Backfilling address transaction index", "blocks", tail)
+	for tail > 0 {
+		var from uint64
+		if tail > addressTxBackfillRange {
+			from = tail - addressTxBackfillRange
+		}
+		next, err := rawdb.IndexAddressTransactions(bc.db, from, tail, bc.chainConfig, bc.quit)
+		if err != nil {
+			log.Error("Failed to backfill address transaction index", "err", err)
+			return
+		}
+		if next < tail {
+			return // interrupted
+		}
+		rawdb.WriteAddressTxIndexTail(bc.db, from)
+		tail = from
+	}
+	log.Info("Backfilled address transaction index")
+}
+
 // empty returns an indicator whether the blockchain is empty.
 // Note, it's a special case that we connect a non-empty ancient
 // database with an empty node, so that we can plugin the ancient
@@ -1053,6 +1103,9 @@ func (bc *BlockChain) writeHeadBlock(block *types.Block) {
 	rawdb.WriteHeadFastBlockHash(batch, block.Hash())
 	rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
 	rawdb.WriteTxLookupEntriesByBlock(batch, block)
+	if bc.cacheConfig.AddressTxIndex {
+		rawdb.WriteAddressTxIndexByBlock(batch, block, types.MakeSigner(bc.chainConfig, block.Number(), block.Time()))
+	}
 	rawdb.WriteHeadBlockHash(batch, block.Hash())
 
 	// Flush the whole batch into the disk, exit the node if failed
@@ -1318,6 +1371,9 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 			}
 			rawdb.DeleteCanonicalHash(batch, block.NumberU64())
 			rawdb.DeleteBlockWithoutNumber(batch, block.Hash(), block.NumberU64())
+			if bc.cacheConfig.AddressTxIndex {
+				rawdb.WriteAddressTxIndexByBlock(batch, block, types.MakeSigner(bc.chainConfig, block.Number(), block.Time()))
+			}
 		}
 		// Delete side chain hash-to-number mappings.
 		for _, nh := range rawdb.ReadAllHashesInRange(bc.db, first.NumberU64(), last.NumberU64()) {
@@ -1362,6 +1418,9 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 			// Write all the data out into the database
 			rawdb.WriteBody(batch, block.Hash(), block.NumberU64(), block.Body())
 			rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), receiptChain[i])
+			if bc.cacheConfig.AddressTxIndex {
+				rawdb.WriteAddressTxIndexByBlock(batch, block, types.MakeSigner(bc.chainConfig, block.Number(), block.Time()))
+			}
 
 			// Write everything belongs to the blocks into the database. So that
 			// we can ensure all components of body is completed(body, receipts)
@@ -2275,6 +2334,17 @@ func (bc *BlockChain) reorg(oldHead *types.Header, newHead *types.Block) error {
 	// reads should be blocked until the mutation is complete.
 	bc.txLookupLock.Lock()
 
+	// Drop the address index entries of the old chain before the new chain is
+	// inserted, as the blocks of both chains share the same index positions.
+	if bc.cacheConfig.AddressTxIndex {
+		batch := bc.db.NewBatch()
+		for _, block := range oldChain {
+			rawdb.DeleteAddressTxIndexByBlock(batch, block, types.MakeSigner(bc.chainConfig, block.Number(), block.Time()))
+		}
+		if err := batch.Write(); err != nil {
+			log.Crit("Failed to delete address transaction index", "err", err)
+		}
+	}
 	// Insert the new chain segment in incremental order, from the old
 	// to the new. The new chain head (newChain[0]) is not inserted here,
 	// as it will be handled separately outside of this function
@@ -1036,7 +1036,9 @@ func testChainTxReorgs(t *testing.T, scheme string) {
 	})
 	// Import the chain. This runs all block validation rules.
 	db := rawdb.NewMemoryDatabase()
-	blockchain, _ := NewBlockChain(db, DefaultCacheConfigWithScheme(scheme), gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	cacheConfig := DefaultCacheConfigWithScheme(scheme)
+	cacheConfig.AddressTxIndex = true
+	blockchain, _ := NewBlockChain(db, cacheConfig, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
 	if i, err := blockchain.InsertChain(chain); err != nil {
 		t.Fatalf("failed to insert original chain[%d]: %v", i, err)
 	}
@@ -1092,6 +1094,101 @@ func testChainTxReorgs(t *testing.T, scheme string) {
 			t.Errorf("share %d: expected receipt to be found", i)
 		}
 	}
+	// address index
+	if hashes := rawdb.ReadAddressTransactions(db, addr2, 0, 10, 0); len(hashes) != 0 {
+		t.Errorf("dropped transactions still indexed: %x", hashes)
+	}
+	for addr, want := range map[common.Address]types.Transactions{
+		addr1: {postponed, swapped},
+		addr3: {pastAdd, freshAdd, futureAdd},
+	} {
+		have := rawdb.ReadTransactionsByAddress(db, addr, 0, 10, 0)
+		if len(have) != len(want) {
+			t.Errorf("%x: wrong number of indexed transactions: have %d, want %d", addr, len(have), len(want))
+			continue
+		}
+		for i := range want {
+			if have[i].Hash() != want[i].Hash() {
+				t.Errorf("%x: indexed tx %d mismatch: have %x, want %x", addr, i, have[i].Hash(), want[i].Hash())
+			}
+		}
+	}
+}
+
+// Tests that the per-address transaction index is backfilled when it's enabled
+// on an existing database, and that blocks imported with receipts are indexed.
+func TestAddressTxIndexBackfill(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		to     = common.Address{0xaa}
+		gspec  = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  types.GenesisAlloc{addr: {Balance: big.NewInt(1000000000000000)}},
+		}
+		signer = types.LatestSigner(gspec.Config)
+	)
+	_, blocks, receipts := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 8, func(i int, gen *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), to, big.NewInt(1000), params.TxGas, gen.header.BaseFee, nil), signer, key)
+		gen.AddTx(tx)
+	})
+	checkIndex := func(name string, db ethdb.Database) {
+		t.Helper()
+		hashes := rawdb.ReadAddressTransactions(db, to, 0, 100, 0)
+		if len(hashes) != len(blocks) {
+			t.Fatalf("%s: wrong number of indexed transactions: have %d, want %d", name, len(hashes), len(blocks))
+		}
+		for i, block := range blocks {
+			if hashes[i] != block.Transactions()[0].Hash() {
+				t.Errorf("%s: indexed tx %d mismatch: have %x, want %x", name, i, hashes[i], block.Transactions()[0].Hash())
+			}
+		}
+	}
+
+	// Import the chain without the index, then enable it.
+	db := rawdb.NewMemoryDatabase()
+	chain, _ := NewBlockChain(db, DefaultCacheConfigWithScheme(rawdb.HashScheme), gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	chain.Stop()
+	if hashes := rawdb.ReadAddressTransactions(db, to, 0, 100, 0); len(hashes) != 0 {
+		t.Fatalf("transactions indexed while disabled: %x", hashes)
+	}
+	cacheConfig := DefaultCacheConfigWithScheme(rawdb.HashScheme)
+	cacheConfig.AddressTxIndex = true
+	chain, _ = NewBlockChain(db, cacheConfig, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if tail := rawdb.ReadAddressTxIndexTail(db); tail != nil && *tail == 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("address transaction index not backfilled")
+		}
+	}
+	chain.Stop()
+	checkIndex("backfill", db)
+
+	// Import the chain with receipts, partially into the ancient store.
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false)
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
+	}
+	defer ancientDb.Close()
+	chain, _ = NewBlockChain(ancientDb, cacheConfig, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer chain.Stop()
+
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if n, err := chain.InsertHeaderChain(headers); err != nil {
+		t.Fatalf("failed to insert header %d: %v", n, err)
+	}
+	if n, err := chain.InsertReceiptChain(blocks, receipts, uint64(len(blocks)/2)); err != nil {
+		t.Fatalf("failed to insert receipt %d: %v", n, err)
+	}
+	checkIndex("receipts", ancientDb)
 }
 
 func TestLogReorgs(t *testing.T) {
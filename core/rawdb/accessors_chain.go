@@ -278,6 +278,32 @@ func WriteTxIndexTail(db ethdb.KeyValueWriter, number uint64) {
 	}
 }
 
+// ReadAddressTxIndexTail retrieves the number of the oldest block covered by the
+// per-address transaction index. The index is complete from there to the head.
+func ReadAddressTxIndexTail(db ethdb.KeyValueReader) *uint64 {
+	data, _ := db.Get(addressTxIndexTailKey)
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+// WriteAddressTxIndexTail stores the number of the oldest block covered by the
+// per-address transaction index into database.
+func WriteAddressTxIndexTail(db ethdb.KeyValueWriter, number uint64) {
+	if err := db.Put(addressTxIndexTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store the address transaction index tail", "err", err)
+	}
+}
+
+// DeleteAddressTxIndexTail removes the tail of the per-address transaction index.
+func DeleteAddressTxIndexTail(db ethdb.KeyValueWriter) {
+	if err := db.Delete(addressTxIndexTailKey); err != nil {
+		log.Crit("Failed to delete the address transaction index tail", "err", err)
+	}
+}
+
 // ReadHeaderRange returns the rlp-encoded headers, starting at 'number', and going
 // backwards towards genesis. This method assumes that the caller already has
 // placed a cap on count, to prevent DoS issues.
@@ -645,6 +671,46 @@ func ReadReceipts(db ethdb.Reader, hash common.Hash, number uint64, time uint64,
 	return receipts
 }
 
+// ReadReceiptsByNumber retrieves all the transaction receipts belonging to the
+// canonical block with the given number, including their metadata fields, along
+// with the hash of the block.
+//
+// If the canonical block is replaced while the receipts are read, the lookup is
+// retried once against the new canonical block. Nil is returned if the mapping
+// keeps changing or the receipts are not available.
+func ReadReceiptsByNumber(db ethdb.Reader, number uint64, config *params.ChainConfig) (common.Hash, types.Receipts) {
+	for attempt := 0; attempt < 2; attempt++ {
+		hash := ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			return common.Hash{}, nil
+		}
+		header := ReadHeader(db, hash, number)
+		if header == nil {
+			return common.Hash{}, nil
+		}
+		receipts := ReadReceipts(db, hash, number, header.Time, config)
+		if ReadCanonicalHash(db, number) == hash {
+			if receipts == nil {
+				return common.Hash{}, nil
+			}
+			return hash, receipts
+		}
+	}
+	return common.Hash{}, nil
+}
+
+// ReadReceiptsInRange calls fn with the receipts of every canonical block in the
+// range [from, to], in ascending order. Iteration stops at the first block whose
+// receipts are not available, or when fn returns false.
+func ReadReceiptsInRange(db ethdb.Reader, from uint64, to uint64, config *params.ChainConfig, fn func(number uint64, hash common.Hash, receipts types.Receipts) bool) {
+	for number := from; number <= to; number++ {
+		hash, receipts := ReadReceiptsByNumber(db, number, config)
+		if receipts == nil || !fn(number, hash, receipts) {
+			return
+		}
+	}
+}
+
 // WriteReceipts stores all the transaction receipts belonging to a block.
 func WriteReceipts(db ethdb.KeyValueWriter, hash common.Hash, number uint64, receipts types.Receipts) {
 	// Convert the receipts into their storage form and serialize them
@@ -29,6 +29,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"golang.org/x/crypto/sha3"
@@ -931,3 +932,82 @@ func TestHeadersRLPStorage(t *testing.T) {
 	checkSequence(1, 1)    // Only block 1
 	checkSequence(1, 2)    // Genesis + block 1
 }
+
+// reorgingReader is an ethdb.Reader which changes the canonical hash of a block
+// number right before it is read for the n-th time, as given by flips.
+type reorgingReader struct {
+	ethdb.Database
+	number uint64
+	flips  map[int]common.Hash
+	reads  int
+}
+
+func (db *reorgingReader) Get(key []byte) ([]byte, error) {
+	if bytes.Equal(key, headerHashKey(db.number)) {
+		db.reads++
+		if hash, ok := db.flips[db.reads]; ok {
+			WriteCanonicalHash(db.Database, hash, db.number)
+		}
+	}
+	return db.Database.Get(key)
+}
+
+func TestReadReceiptsByNumber(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	// Write two competing blocks at every height, with the first one canonical.
+	var blocks [][2]*types.Block
+	for number := uint64(1); number <= 3; number++ {
+		var pair [2]*types.Block
+		for i := range pair {
+			tx := types.NewTransaction(number*10+uint64(i), common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+			header := &types.Header{Number: new(big.Int).SetUint64(number), Extra: []byte{byte(i)}}
+			block := types.NewBlock(header, &types.Body{Transactions: types.Transactions{tx}}, nil, newTestHasher())
+			WriteBlock(db, block)
+			WriteReceipts(db, block.Hash(), number, types.Receipts{{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: number, Logs: []*types.Log{}}})
+			pair[i] = block
+		}
+		WriteCanonicalHash(db, pair[0].Hash(), number)
+		blocks = append(blocks, pair)
+	}
+	check := func(db ethdb.Reader, number uint64, want *types.Block) {
+		t.Helper()
+		hash, receipts := ReadReceiptsByNumber(db, number, params.TestChainConfig)
+		if want == nil {
+			if receipts != nil {
+				t.Fatalf("block %d: unexpected receipts", number)
+			}
+			return
+		}
+		if hash != want.Hash() || len(receipts) != 1 || receipts[0].BlockHash != want.Hash() || receipts[0].TxHash != want.Transactions()[0].Hash() {
+			t.Fatalf("block %d: wrong receipts for %x", number, hash)
+		}
+	}
+	check(db, 1, blocks[0][0])
+	check(db, 4, nil)
+
+	// The canonical block changes between reading the hash and the receipts.
+	a, b := blocks[1][0].Hash(), blocks[1][1].Hash()
+	check(&reorgingReader{Database: db, number: 2, flips: map[int]common.Hash{2: b}}, 2, blocks[1][1])
+
+	// The canonical block keeps changing.
+	check(&reorgingReader{Database: db, number: 2, flips: map[int]common.Hash{2: a, 4: b}}, 2, nil)
+
+	// Range iteration stops at the first missing block and when requested.
+	var visited []common.Hash
+	ReadReceiptsInRange(db, 1, 10, params.TestChainConfig, func(number uint64, hash common.Hash, receipts types.Receipts) bool {
+		visited = append(visited, hash)
+		return true
+	})
+	if len(visited) != 3 {
+		t.Fatalf("wrong number of visited blocks: %d", len(visited))
+	}
+	visited = visited[:0]
+	ReadReceiptsInRange(db, 1, 10, params.TestChainConfig, func(number uint64, hash common.Hash, receipts types.Receipts) bool {
+		visited = append(visited, hash)
+		return number < 2
+	})
+	if len(visited) != 2 {
+		t.Fatalf("wrong number of visited blocks after early stop: %d", len(visited))
+	}
+}
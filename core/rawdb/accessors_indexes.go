@@ -18,6 +18,7 @@ package rawdb
 
 import (
 	"bytes"
+	"encoding/binary"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -93,6 +94,102 @@ func DeleteTxLookupEntries(db ethdb.KeyValueWriter, hashes []common.Hash) {
 	}
 }
 
+// txAddresses returns the sender and the recipient of a transaction, skipping the
+// recipient of contract creations and self-transfers.
+func txAddresses(signer types.Signer, tx *types.Transaction) []common.Address {
+	addrs := make([]common.Address, 0, 2)
+	if from, err := types.Sender(signer, tx); err != nil {
+		log.Error("Failed to derive transaction sender", "hash", tx.Hash(), "err", err)
+	} else {
+		addrs = append(addrs, from)
+	}
+	if to := tx.To(); to != nil && (len(addrs) == 0 || *to != addrs[0]) {
+		addrs = append(addrs, *to)
+	}
+	return addrs
+}
+
+// WriteAddressTxIndexByBlock stores the per-address index entries of every
+// transaction in a block, keyed by both sender and recipient.
+func WriteAddressTxIndexByBlock(db ethdb.KeyValueWriter, block *types.Block, signer types.Signer) {
+	number := block.NumberU64()
+	for i, tx := range block.Transactions() {
+		for _, addr := range txAddresses(signer, tx) {
+			if err := db.Put(addressTxKey(addr, number, uint32(i)), tx.Hash().Bytes()); err != nil {
+				log.Crit("Failed to store address transaction index", "err", err)
+			}
+		}
+	}
+}
+
+// DeleteAddressTxIndexByBlock removes the per-address index entries of every
+// transaction in a block.
+func DeleteAddressTxIndexByBlock(db ethdb.KeyValueWriter, block *types.Block, signer types.Signer) {
+	number := block.NumberU64()
+	for i, tx := range block.Transactions() {
+		for _, addr := range txAddresses(signer, tx) {
+			if err := db.Delete(addressTxKey(addr, number, uint32(i))); err != nil {
+				log.Crit("Failed to delete address transaction index", "err", err)
+			}
+		}
+	}
+}
+
+// iterateAddressTxIndex calls fn with the position and hash of every index entry
+// of the given address in the block range [from, to], in chain order, until fn
+// returns false.
+func iterateAddressTxIndex(db ethdb.Iteratee, address common.Address, from, to uint64, fn func(number uint64, index uint32, hash common.Hash) bool) {
+	prefix := append(addressTxPrefix, address.Bytes()...)
+	it := db.NewIterator(prefix, encodeBlockNumber(from))
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(prefix)+8+4 {
+			continue
+		}
+		number := binary.BigEndian.Uint64(key[len(prefix):])
+		if number > to {
+			return
+		}
+		if !fn(number, binary.BigEndian.Uint32(key[len(prefix)+8:]), common.BytesToHash(it.Value())) {
+			return
+		}
+	}
+}
+
+// ReadAddressTransactions retrieves the hashes of the transactions sent from or
+// to the given address in the block range [from, to], in chain order. At most
+// limit hashes are returned, zero meaning no limit.
+//
+// The index is not filtered by canonical status, use ReadTransactionsByAddress
+// to only retrieve the transactions of the canonical chain.
+func ReadAddressTransactions(db ethdb.Iteratee, address common.Address, from, to uint64, limit int) []common.Hash {
+	var hashes []common.Hash
+	iterateAddressTxIndex(db, address, from, to, func(number uint64, index uint32, hash common.Hash) bool {
+		hashes = append(hashes, hash)
+		return limit <= 0 || len(hashes) < limit
+	})
+	return hashes
+}
+
+// ReadTransactionsByAddress retrieves the canonical transactions sent from or to
+// the given address in the block range [from, to], in chain order. Index entries
+// which no longer match the canonical chain are skipped. At most limit
+// transactions are returned, zero meaning no limit.
+func ReadTransactionsByAddress(db ethdb.Database, address common.Address, from, to uint64, limit int) []*types.Transaction {
+	var txs []*types.Transaction
+	iterateAddressTxIndex(db, address, from, to, func(number uint64, index uint32, hash common.Hash) bool {
+		tx, _, txNumber, txIndex := ReadTransaction(db, hash)
+		if tx == nil || txNumber != number || txIndex != uint64(index) {
+			return true
+		}
+		txs = append(txs, tx)
+		return limit <= 0 || len(txs) < limit
+	})
+	return txs
+}
+
 // ReadTransaction retrieves a specific transaction from the database, along with
 // its added positional metadata.
 func ReadTransaction(db ethdb.Reader, hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64) {
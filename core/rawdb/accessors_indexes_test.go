@@ -19,10 +19,12 @@ package rawdb
 import (
 	"bytes"
 	"math/big"
+	"reflect"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/internal/blocktest"
 	"github.com/ethereum/go-ethereum/params"
@@ -154,3 +156,64 @@ func TestDeleteBloomBits(t *testing.T) {
 	check(1, 1, params.MainnetGenesisHash, true)
 	check(1, 1, params.SepoliaGenesisHash, true)
 }
+
+// Tests that the per-address transaction index can be stored, queried and
+// removed.
+func TestAddressTxIndex(t *testing.T) {
+	var (
+		db     = NewMemoryDatabase()
+		signer = types.LatestSigner(params.TestChainConfig)
+		key, _ = crypto.GenerateKey()
+		sender = crypto.PubkeyToAddress(key.PublicKey)
+		other  = common.BytesToAddress([]byte{0x11})
+		blocks []*types.Block
+	)
+	for i := uint64(1); i <= 3; i++ {
+		tx1, _ := types.SignTx(types.NewTransaction(2*i, other, big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+		tx2, _ := types.SignTx(types.NewContractCreation(2*i+1, big.NewInt(0), 100000, big.NewInt(1), nil), signer, key)
+		block := types.NewBlock(&types.Header{Number: new(big.Int).SetUint64(i)}, &types.Body{Transactions: types.Transactions{tx1, tx2}}, nil, newTestHasher())
+		WriteAddressTxIndexByBlock(db, block, signer)
+		blocks = append(blocks, block)
+	}
+	hashes := func(txs ...*types.Transaction) []common.Hash {
+		var hs []common.Hash
+		for _, tx := range txs {
+			hs = append(hs, tx.Hash())
+		}
+		return hs
+	}
+	check := func(addr common.Address, from, to uint64, limit int, want []common.Hash) {
+		t.Helper()
+		if have := ReadAddressTransactions(db, addr, from, to, limit); !reflect.DeepEqual(have, want) {
+			t.Errorf("%x [%d, %d] limit %d: have %x, want %x", addr, from, to, limit, have, want)
+		}
+	}
+	b1, b2, b3 := blocks[0].Transactions(), blocks[1].Transactions(), blocks[2].Transactions()
+	check(sender, 0, 10, 0, hashes(b1[0], b1[1], b2[0], b2[1], b3[0], b3[1]))
+	check(sender, 2, 2, 0, hashes(b2[0], b2[1]))
+	check(sender, 2, 10, 3, hashes(b2[0], b2[1], b3[0]))
+	check(other, 0, 10, 0, hashes(b1[0], b2[0], b3[0]))
+	check(other, 4, 10, 0, nil)
+	check(common.Address{}, 0, 10, 0, nil)
+
+	// Only entries of canonical blocks resolve to transactions.
+	for _, block := range blocks[:2] {
+		WriteBlock(db, block)
+		WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		WriteTxLookupEntriesByBlock(db, block)
+	}
+	var have []common.Hash
+	for _, tx := range ReadTransactionsByAddress(db, sender, 0, 10, 3) {
+		have = append(have, tx.Hash())
+	}
+	if want := hashes(b1[0], b1[1], b2[0]); !reflect.DeepEqual(have, want) {
+		t.Errorf("wrong canonical transactions: have %x, want %x", have, want)
+	}
+	if txs := ReadTransactionsByAddress(db, other, 3, 10, 0); len(txs) != 0 {
+		t.Errorf("non-canonical transactions returned: %d", len(txs))
+	}
+
+	DeleteAddressTxIndexByBlock(db, blocks[1], signer)
+	check(sender, 0, 10, 0, hashes(b1[0], b1[1], b3[0], b3[1]))
+	check(other, 0, 10, 0, hashes(b1[0], b3[0]))
+}
@@ -17,6 +17,7 @@
 package rawdb
 
 import (
+	"fmt"
 	"runtime"
 	"sync/atomic"
 	"time"
@@ -26,6 +27,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
@@ -361,3 +363,130 @@ func UnindexTransactions(db ethdb.Database, from uint64, to uint64, interrupt ch
 func unindexTransactionsForTesting(db ethdb.Database, from uint64, to uint64, interrupt chan struct{}, hook func(uint64) bool) {
 	unindexTransactions(db, from, to, interrupt, hook, false)
 }
+
+// DeleteStaleTxLookupEntries scans all transaction lookup entries and removes the
+// ones that don't point to a canonical block containing the transaction. Such
+// entries can be left behind if the node goes down in the middle of a chain
+// reorganisation. It returns the number of removed entries.
+//
+// There is a passed channel, the whole procedure will be interrupted if any
+// signal received.
+func DeleteStaleTxLookupEntries(db ethdb.Database, interrupt chan struct{}) (int, error) {
+	var (
+		it      = db.NewIterator(txLookupPrefix, nil)
+		batch   = db.NewBatch()
+		start   = time.Now()
+		logged  = start
+		blocks  = make(map[uint64]map[common.Hash]struct{}) // tx hashes of recently read blocks
+		checked int
+		deleted int
+	)
+	defer it.Release()
+
+	for it.Next() {
+		select {
+		case <-interrupt:
+			log.Debug("Stale transaction lookup removal interrupted", "checked", checked, "deleted", deleted)
+			return deleted, batch.Write()
+		default:
+		}
+		key := it.Key()
+		if len(key) != len(txLookupPrefix)+common.HashLength {
+			continue
+		}
+		hash := common.BytesToHash(key[len(txLookupPrefix):])
+		checked++
+
+		stale := true
+		if number := ReadTxLookupEntry(db, hash); number != nil {
+			txs, ok := blocks[*number]
+			if !ok {
+				txs = make(map[common.Hash]struct{})
+				if body := ReadBody(db, ReadCanonicalHash(db, *number), *number); body != nil {
+					for _, tx := range body.Transactions {
+						txs[tx.Hash()] = struct{}{}
+					}
+				}
+				// Entries are ordered by hash, so block accesses are random.
+				// Keep the cache bounded.
+				if len(blocks) >= 1024 {
+					clear(blocks)
+				}
+				blocks[*number] = txs
+			}
+			_, found := txs[hash]
+			stale = !found
+		}
+		if stale {
+			DeleteTxLookupEntry(batch, hash)
+			deleted++
+			if batch.ValueSize() > ethdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					return deleted, err
+				}
+				batch.Reset()
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Removing stale transaction lookups", "checked", checked, "deleted", deleted, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return deleted, err
+	}
+	if err := batch.Write(); err != nil {
+		return deleted, err
+	}
+	log.Debug("Removed stale transaction lookups", "checked", checked, "deleted", deleted, "elapsed", common.PrettyDuration(time.Since(start)))
+	return deleted, nil
+}
+
+// IndexAddressTransactions builds the per-address transaction index of the
+// canonical blocks in the range [from, to). It returns the number of the first
+// block which was not indexed, so an interrupted run can be resumed from there.
+//
+// There is a passed channel, the whole procedure will be interrupted if any
+// signal received.
+func IndexAddressTransactions(db ethdb.Database, from uint64, to uint64, config *params.ChainConfig, interrupt chan struct{}) (uint64, error) {
+	var (
+		batch  = db.NewBatch()
+		start  = time.Now()
+		logged = start
+		txs    int
+	)
+	for number := from; number < to; number++ {
+		select {
+		case <-interrupt:
+			log.Debug("Address transaction indexing interrupted", "blocks", number-from, "txs", txs)
+			return number, batch.Write()
+		default:
+		}
+		block := ReadBlock(db, ReadCanonicalHash(db, number), number)
+		if block == nil {
+			if err := batch.Write(); err != nil {
+				return number, err
+			}
+			return number, fmt.Errorf("canonical block #%d not found", number)
+		}
+		signer := types.MakeSigner(config, block.Number(), block.Time())
+		WriteAddressTxIndexByBlock(batch, block, signer)
+		txs += len(block.Transactions())
+
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return number, err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Indexing address transactions", "blocks", number-from, "txs", txs, "total", to-from, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return to, err
+	}
+	log.Debug("Indexed address transactions", "blocks", to-from, "txs", txs, "elapsed", common.PrettyDuration(time.Since(start)))
+	return to, nil
+}
@@ -25,6 +25,9 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 func TestChainIterator(t *testing.T) {
@@ -206,3 +209,113 @@ func TestIndexTransactions(t *testing.T) {
 	verify(8, 11, true, 8)
 	verify(0, 8, false, 8)
 }
+
+func TestDeleteStaleTxLookupEntries(t *testing.T) {
+	chainDb := NewMemoryDatabase()
+
+	to := common.BytesToAddress([]byte{0x11})
+	newTx := func(nonce uint64) *types.Transaction {
+		return types.NewTx(&types.LegacyTx{Nonce: nonce, GasPrice: big.NewInt(1), Gas: 21000, To: &to})
+	}
+	var canonical, orphaned []*types.Transaction
+	for i := uint64(1); i <= 3; i++ {
+		tx := newTx(i)
+		block := types.NewBlock(&types.Header{Number: new(big.Int).SetUint64(i)}, &types.Body{Transactions: types.Transactions{tx}}, nil, newTestHasher())
+		WriteBlock(chainDb, block)
+		WriteCanonicalHash(chainDb, block.Hash(), i)
+		WriteTxLookupEntriesByBlock(chainDb, block)
+		canonical = append(canonical, tx)
+	}
+	// A side block at height 2 whose lookups were not removed, and a lookup
+	// pointing to a block which doesn't exist.
+	orphaned = append(orphaned, newTx(100), newTx(101))
+	side := types.NewBlock(&types.Header{Number: big.NewInt(2), Extra: []byte("side")}, &types.Body{Transactions: orphaned[:1]}, nil, newTestHasher())
+	WriteBlock(chainDb, side)
+	WriteTxLookupEntriesByBlock(chainDb, side)
+	WriteTxLookupEntries(chainDb, 10, []common.Hash{orphaned[1].Hash()})
+
+	deleted, err := DeleteStaleTxLookupEntries(chainDb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != len(orphaned) {
+		t.Fatalf("wrong number of deleted entries: got %d, want %d", deleted, len(orphaned))
+	}
+	for _, tx := range orphaned {
+		if ReadTxLookupEntry(chainDb, tx.Hash()) != nil {
+			t.Errorf("stale lookup of %x not deleted", tx.Hash())
+		}
+	}
+	for _, tx := range canonical {
+		if txn, _, _, _ := ReadTransaction(chainDb, tx.Hash()); txn == nil {
+			t.Errorf("lookup of canonical transaction %x deleted", tx.Hash())
+		}
+	}
+}
+
+func TestIndexAddressTransactions(t *testing.T) {
+	var (
+		chainDb = NewMemoryDatabase()
+		signer  = types.LatestSigner(params.TestChainConfig)
+		key, _  = crypto.GenerateKey()
+		sender  = crypto.PubkeyToAddress(key.PublicKey)
+		to      = common.BytesToAddress([]byte{0x11})
+		txs     []common.Hash
+	)
+	for i := uint64(0); i < 10; i++ {
+		tx, _ := types.SignTx(types.NewTransaction(i, to, big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+		block := types.NewBlock(&types.Header{Number: new(big.Int).SetUint64(i)}, &types.Body{Transactions: types.Transactions{tx}}, nil, newTestHasher())
+		WriteBlock(chainDb, block)
+		WriteCanonicalHash(chainDb, block.Hash(), i)
+		txs = append(txs, tx.Hash())
+	}
+	// An interrupted run indexes nothing and reports where to resume.
+	interrupt := make(chan struct{})
+	close(interrupt)
+	next, err := IndexAddressTransactions(chainDb, 0, 10, params.TestChainConfig, interrupt)
+	if err != nil || next != 0 {
+		t.Fatalf("interrupted run: next %d, err %v", next, err)
+	}
+	// Index in two steps, resuming from the returned block.
+	if next, err = IndexAddressTransactions(chainDb, 0, 5, params.TestChainConfig, nil); err != nil || next != 5 {
+		t.Fatalf("first run: next %d, err %v", next, err)
+	}
+	if next, err = IndexAddressTransactions(chainDb, next, 10, params.TestChainConfig, nil); err != nil || next != 10 {
+		t.Fatalf("second run: next %d, err %v", next, err)
+	}
+	for _, addr := range []common.Address{sender, to} {
+		if have := ReadAddressTransactions(chainDb, addr, 0, 10, 0); !reflect.DeepEqual(have, txs) {
+			t.Errorf("wrong transactions of %x: have %x, want %x", addr, have, txs)
+		}
+	}
+	// Missing canonical blocks stop the indexing.
+	if next, err = IndexAddressTransactions(chainDb, 8, 12, params.TestChainConfig, nil); err == nil || next != 10 {
+		t.Fatalf("missing block: next %d, err %v", next, err)
+	}
+}
+
+// This benchmark measures the cost of indexing a block, which is dominated by
+// sender recovery.
+func BenchmarkWriteAddressTxIndex(b *testing.B) {
+	var (
+		signer = types.LatestSigner(params.TestChainConfig)
+		key, _ = crypto.GenerateKey()
+		to     = common.BytesToAddress([]byte{0x11})
+		txs    types.Transactions
+	)
+	for i := uint64(0); i < 200; i++ {
+		tx, _ := types.SignTx(types.NewTransaction(i, to, big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+		txs = append(txs, tx)
+	}
+	data, _ := rlp.EncodeToBytes(txs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Decode a fresh copy of the transactions, so the sender cache is cold.
+		b.StopTimer()
+		var fresh types.Transactions
+		rlp.DecodeBytes(data, &fresh)
+		block := types.NewBlock(&types.Header{Number: big.NewInt(1)}, &types.Body{Transactions: fresh}, nil, newTestHasher())
+		b.StartTimer()
+		WriteAddressTxIndexByBlock(NewMemoryDatabase(), block, signer)
+	}
+}
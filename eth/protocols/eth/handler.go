@@ -96,9 +96,10 @@ func MakeProtocols(backend Backend, network uint64, dnsdisc enode.Iterator) []p2
 		version := version // Closure
 
 		protocols = append(protocols, p2p.Protocol{
-			Name:    ProtocolName,
-			Version: version,
-			Length:  protocolLengths[version],
+			Name:       ProtocolName,
+			Version:    version,
+			Length:     protocolLengths[version],
+			MaxMsgSize: maxMessageSize,
 			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 				peer := NewPeer(version, p, rw, backend.TxPool())
 				defer peer.Close()
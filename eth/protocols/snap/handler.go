@@ -94,9 +94,10 @@ func MakeProtocols(backend Backend, dnsdisc enode.Iterator) []p2p.Protocol {
 		version := version // Closure
 
 		protocols[i] = p2p.Protocol{
-			Name:    ProtocolName,
-			Version: version,
-			Length:  protocolLengths[version],
+			Name:       ProtocolName,
+			Version:    version,
+			Length:     protocolLengths[version],
+			MaxMsgSize: maxMessageSize,
 			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 				return backend.RunPeer(NewPeer(version, p, rw), func(peer *Peer) error {
 					return Handle(backend, peer)
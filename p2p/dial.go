@@ -80,6 +80,7 @@ var (
 	errRecentlyDialed   = errors.New("recently dialed")
 	errNetRestrict      = errors.New("not contained in netrestrict list")
 	errNoPort           = errors.New("node does not provide TCP port")
+	errLowReputation    = errors.New("low reputation")
 )
 
 // dialer creates outbound connections and submits them into Server.
@@ -138,6 +139,8 @@ type dialConfig struct {
 	log            log.Logger
 	clock          mclock.Clock
 	rand           *mrand.Rand
+	deprioritized  func(enode.ID) bool           // skips dynamic dial candidates, may be nil
+	bannedUntil    func(enode.ID) mclock.AbsTime // end of the ban of a static node, may be nil
 }
 
 func (cfg dialConfig) withDefaults() dialConfig {
@@ -242,7 +245,7 @@ loop:
 
 		select {
 		case node := <-nodesCh:
-			if err := d.checkDial(node); err != nil {
+			if err := d.checkDynDial(node); err != nil {
 				d.log.Trace("Discarding dial candidate", "id", node.ID(), "ip", node.IP(), "reason", err)
 			} else {
 				d.startDial(newDialTask(node, dynDialedConn))
@@ -371,6 +374,17 @@ func (d *dialScheduler) freeDialSlots() int {
 	return free
 }
 
+// checkDynDial returns an error if the dynamic dial candidate n should not be dialed.
+func (d *dialScheduler) checkDynDial(n *enode.Node) error {
+	if err := d.checkDial(n); err != nil {
+		return err
+	}
+	if d.deprioritized != nil && d.deprioritized(n.ID()) {
+		return errLowReputation
+	}
+	return nil
+}
+
 // checkDial returns an error if node n should not be dialed.
 func (d *dialScheduler) checkDial(n *enode.Node) error {
 	if n.ID() == d.self {
@@ -399,11 +413,24 @@ func (d *dialScheduler) checkDial(n *enode.Node) error {
 
 // startStaticDials starts n static dial tasks.
 func (d *dialScheduler) startStaticDials(n int) (started int) {
-	for started = 0; started < n && len(d.staticPool) > 0; started++ {
+	for started < n && len(d.staticPool) > 0 {
 		idx := d.rand.Intn(len(d.staticPool))
 		task := d.staticPool[idx]
-		d.startDial(task)
 		d.removeFromStaticPool(idx)
+		// Banned nodes are put into the history instead of being dialed. They
+		// return to the pool and are checked again when the entry expires.
+		if d.bannedUntil != nil {
+			now := d.clock.Now()
+			if until := d.bannedUntil(task.dest().ID()); until > now {
+				if max := now.Add(dialHistoryExpiration); until > max {
+					until = max
+				}
+				d.history.add(string(task.dest().ID().Bytes()), until)
+				continue
+			}
+		}
+		d.startDial(task)
+		started++
 	}
 	return started
 }
@@ -152,6 +152,95 @@ func TestDialSchedNetRestrict(t *testing.T) {
 	})
 }
 
+// This test checks that our own node is never dialed, even when it is returned
+// by discovery.
+func TestDialSchedSelf(t *testing.T) {
+	t.Parallel()
+
+	nodes := []*enode.Node{
+		newNode(uintID(0x01), "127.0.0.1:30303"),
+		newNode(uintID(0x02), "127.0.0.2:30303"),
+	}
+	config := dialConfig{
+		self:           nodes[0].ID(),
+		maxActiveDials: 10,
+		maxDialPeers:   10,
+	}
+	runDialTest(t, config, []dialTestRound{
+		{
+			discovered:   nodes,
+			wantNewDials: nodes[1:],
+		},
+		{
+			succeeded: []enode.ID{nodes[1].ID()},
+		},
+	})
+}
+
+// This test checks that dynamic dial candidates with low reputation are skipped.
+func TestDialSchedDeprioritized(t *testing.T) {
+	t.Parallel()
+
+	nodes := []*enode.Node{
+		newNode(uintID(0x01), "127.0.0.1:30303"),
+		newNode(uintID(0x02), "127.0.0.2:30303"),
+		newNode(uintID(0x03), "127.0.0.3:30303"),
+	}
+	config := dialConfig{
+		maxActiveDials: 10,
+		maxDialPeers:   10,
+		deprioritized: func(id enode.ID) bool {
+			return id == nodes[1].ID()
+		},
+	}
+	runDialTest(t, config, []dialTestRound{
+		{
+			discovered:   nodes,
+			wantNewDials: []*enode.Node{nodes[0], nodes[2]},
+		},
+		{
+			succeeded: []enode.ID{nodes[0].ID(), nodes[2].ID()},
+		},
+	})
+}
+
+// This test checks that banned static nodes are not dialed until the ban ends.
+func TestDialSchedBannedStatic(t *testing.T) {
+	t.Parallel()
+
+	nodes := []*enode.Node{
+		newNode(uintID(0x01), "127.0.0.1:30303"),
+		newNode(uintID(0x02), "127.0.0.2:30303"),
+	}
+	config := dialConfig{
+		maxActiveDials: 5,
+		maxDialPeers:   4,
+		bannedUntil: func(id enode.ID) mclock.AbsTime {
+			if id == nodes[1].ID() {
+				return mclock.AbsTime(40 * time.Second)
+			}
+			return 0
+		},
+	}
+	runDialTest(t, config, []dialTestRound{
+		{
+			update: func(d *dialScheduler) {
+				d.addStatic(nodes[0])
+				d.addStatic(nodes[1])
+			},
+			wantNewDials: nodes[:1],
+		},
+		{
+			succeeded: []enode.ID{nodes[0].ID()},
+		},
+		{},
+		// The ban has ended, 0x02 is dialed.
+		{
+			wantNewDials: nodes[1:],
+		},
+	})
+}
+
 // This test checks that static dials work and obey the limits.
 func TestDialSchedStaticDial(t *testing.T) {
 	t.Parallel()
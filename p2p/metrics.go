@@ -51,6 +51,12 @@ var (
 	dialSuccessMeter    metrics.Meter = metrics.NilMeter{}
 	dialConnectionError metrics.Meter = metrics.NilMeter{}
 
+	// session duration of disconnected peers, in milliseconds
+	sessionDurationHistogram metrics.Histogram = metrics.NilHistogram{}
+
+	// smoothed round-trip times of peers, in microseconds
+	rttHistogram metrics.Histogram = metrics.NilHistogram{}
+
 	// handshake error meters
 	dialTooManyPeers        = metrics.NewRegisteredMeter("p2p/dials/error/saturated", nil)
 	dialAlreadyConnected    = metrics.NewRegisteredMeter("p2p/dials/error/known", nil)
@@ -59,6 +65,10 @@ var (
 	dialUnexpectedIdentity  = metrics.NewRegisteredMeter("p2p/dials/error/id/unexpected", nil)
 	dialEncHandshakeError   = metrics.NewRegisteredMeter("p2p/dials/error/rlpx/enc", nil)
 	dialProtoHandshakeError = metrics.NewRegisteredMeter("p2p/dials/error/rlpx/proto", nil)
+	dialHandshakeTimeout    = metrics.NewRegisteredMeter("p2p/dials/error/timeout", nil)
+
+	// handshake timeouts of inbound connections
+	serveHandshakeTimeout = metrics.NewRegisteredMeter("p2p/serves/error/timeout", nil)
 )
 
 func init() {
@@ -74,6 +84,8 @@ func init() {
 	dialMeter = metrics.NewRegisteredMeter("p2p/dials", nil)
 	dialSuccessMeter = metrics.NewRegisteredMeter("p2p/dials/success", nil)
 	dialConnectionError = metrics.NewRegisteredMeter("p2p/dials/error/connection", nil)
+	sessionDurationHistogram = metrics.NewRegisteredHistogram("p2p/peers/session", nil, metrics.NewExpDecaySample(1028, 0.015))
+	rttHistogram = metrics.NewRegisteredHistogram("p2p/peers/rtt", nil, metrics.NewExpDecaySample(1028, 0.015))
 }
 
 // markDialError matches errors that occur while setting up a dial connection
@@ -100,6 +112,8 @@ func markDialError(err error) {
 		dialEncHandshakeError.Mark(1)
 	case errProtoHandshakeError:
 		dialProtoHandshakeError.Mark(1)
+	case errHandshakeTimeout:
+		dialHandshakeTimeout.Mark(1)
 	}
 }
 
@@ -0,0 +1,186 @@
+// Copyright 2026 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ErrMsgDropped can be returned by a MsgHook to drop a message silently.
+var ErrMsgDropped = errors.New("message dropped")
+
+// MsgDirection is the direction of a message passing through a MsgHook.
+type MsgDirection int
+
+const (
+	MsgInbound MsgDirection = iota
+	MsgOutbound
+)
+
+func (d MsgDirection) String() string {
+	if d == MsgInbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// MsgHook is called for every message passing through a MsgReadWriter wrapped by
+// WrapMsgReadWriter. The hook may inspect and modify the message, including
+// replacing its payload.
+//
+// Returning ErrMsgDropped drops the message: dropped inbound messages are
+// discarded and never returned by ReadMsg, dropped outbound messages are not
+// written and WriteMsg reports success. Returning any other error vetoes the
+// message and the error is returned from ReadMsg or WriteMsg.
+type MsgHook func(dir MsgDirection, msg *Msg) error
+
+type hookedMsgReadWriter struct {
+	MsgReadWriter
+	hooks []MsgHook
+}
+
+// WrapMsgReadWriter returns a MsgReadWriter which runs all messages through the
+// given hooks, in order. Inbound messages are passed to the hooks after they have
+// been read from rw, outbound messages before they are written to rw.
+func WrapMsgReadWriter(rw MsgReadWriter, hooks ...MsgHook) MsgReadWriter {
+	if len(hooks) == 0 {
+		return rw
+	}
+	return &hookedMsgReadWriter{MsgReadWriter: rw, hooks: hooks}
+}
+
+func (rw *hookedMsgReadWriter) ReadMsg() (Msg, error) {
+	for {
+		msg, err := rw.MsgReadWriter.ReadMsg()
+		if err != nil {
+			return msg, err
+		}
+		err = rw.run(MsgInbound, &msg)
+		if err == nil {
+			return msg, nil
+		}
+		msg.Discard()
+		if err != ErrMsgDropped {
+			return Msg{}, err
+		}
+	}
+}
+
+func (rw *hookedMsgReadWriter) WriteMsg(msg Msg) error {
+	if err := rw.run(MsgOutbound, &msg); err != nil {
+		if err == ErrMsgDropped {
+			return nil
+		}
+		return err
+	}
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
+
+func (rw *hookedMsgReadWriter) run(dir MsgDirection, msg *Msg) error {
+	for _, hook := range rw.hooks {
+		if err := hook(dir, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying MsgReadWriter if it implements the io.Closer
+// interface.
+func (rw *hookedMsgReadWriter) Close() error {
+	if v, ok := rw.MsgReadWriter.(io.Closer); ok {
+		return v.Close()
+	}
+	return nil
+}
+
+// NewMsgLogHook returns a hook which logs the code and size of every message.
+// For inbound messages, it also logs the time elapsed since the message was
+// received from the network.
+func NewMsgLogHook(logger log.Logger) MsgHook {
+	if logger == nil {
+		logger = log.Root()
+	}
+	return func(dir MsgDirection, msg *Msg) error {
+		if dir == MsgInbound && !msg.ReceivedAt.IsZero() {
+			logger.Trace("P2P message", "dir", dir, "code", msg.Code, "size", msg.Size, "latency", time.Since(msg.ReceivedAt))
+		} else {
+			logger.Trace("P2P message", "dir", dir, "code", msg.Code, "size", msg.Size)
+		}
+		return nil
+	}
+}
+
+// FaultPolicy configures the faults injected by NewFaultHook. Rates are
+// probabilities between zero and one, evaluated independently for every message.
+type FaultPolicy struct {
+	Seed        int64         // seed of the random source, for reproducible runs
+	Inbound     bool          // inject faults into inbound messages
+	Outbound    bool          // inject faults into outbound messages
+	DropRate    float64       // probability of dropping a message
+	CorruptRate float64       // probability of flipping a random payload byte
+	DelayRate   float64       // probability of delaying a message
+	Delay       time.Duration // delay applied to delayed messages
+}
+
+// NewFaultHook returns a hook which delays, drops or corrupts messages according
+// to the given policy. It is meant for testing.
+func NewFaultHook(policy FaultPolicy) MsgHook {
+	var (
+		mu  sync.Mutex
+		rng = rand.New(rand.NewSource(policy.Seed))
+	)
+	return func(dir MsgDirection, msg *Msg) error {
+		if (dir == MsgInbound && !policy.Inbound) || (dir == MsgOutbound && !policy.Outbound) {
+			return nil
+		}
+		mu.Lock()
+		var (
+			drop    = rng.Float64() < policy.DropRate
+			corrupt = rng.Float64() < policy.CorruptRate
+			delay   = rng.Float64() < policy.DelayRate
+			pos     = rng.Int()
+			bit     = byte(1 << uint(rng.Intn(8)))
+		)
+		mu.Unlock()
+
+		if drop {
+			return ErrMsgDropped
+		}
+		if delay {
+			time.Sleep(policy.Delay)
+		}
+		if corrupt && msg.Size > 0 {
+			data, err := io.ReadAll(msg.Payload)
+			if err != nil {
+				return err
+			}
+			if len(data) > 0 {
+				data[pos%len(data)] ^= bit
+			}
+			msg.Payload = bytes.NewReader(data)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2026 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestWrapMsgReadWriter(t *testing.T) {
+	var (
+		rw1, rw2 = MsgPipe()
+		errVeto  = errors.New("veto")
+		seen     []MsgDirection
+	)
+	defer rw1.Close()
+
+	hook := func(dir MsgDirection, msg *Msg) error {
+		seen = append(seen, dir)
+		switch msg.Code {
+		case 1:
+			return ErrMsgDropped
+		case 2:
+			return errVeto
+		case 3:
+			msg.Code = 4
+		}
+		return nil
+	}
+	w1 := WrapMsgReadWriter(rw1, hook)
+	w2 := WrapMsgReadWriter(rw2, hook)
+
+	// Outbound: dropped messages report success, vetoed ones fail.
+	if err := Send(w1, 1, []uint{1}); err != nil {
+		t.Fatalf("dropped send failed: %v", err)
+	}
+	if err := Send(w1, 2, []uint{2}); err != errVeto {
+		t.Fatalf("vetoed send returned %v", err)
+	}
+
+	// Inbound: the dropped message is skipped and the code is rewritten.
+	go func() {
+		Send(rw1, 1, []uint{1})
+		Send(rw1, 3, []uint{3})
+	}()
+	if err := ExpectMsg(w2, 4, []uint{3}); err != nil {
+		t.Fatal(err)
+	}
+	want := []MsgDirection{MsgOutbound, MsgOutbound, MsgInbound, MsgInbound}
+	if !reflect.DeepEqual(seen, want) {
+		t.Fatalf("wrong hook calls: got %v, want %v", seen, want)
+	}
+}
+
+func TestFaultHook(t *testing.T) {
+	run := func(policy FaultPolicy) []error {
+		var (
+			hook = NewFaultHook(policy)
+			errs []error
+		)
+		for i := 0; i < 50; i++ {
+			size, r, _ := rlp.EncodeToReader([]uint{uint(i)})
+			msg := Msg{Code: 0, Size: uint32(size), Payload: r}
+			errs = append(errs, hook(MsgOutbound, &msg))
+		}
+		return errs
+	}
+
+	// The same seed produces the same faults.
+	policy := FaultPolicy{Seed: 1, Outbound: true, DropRate: 0.5}
+	errs := run(policy)
+	if !reflect.DeepEqual(errs, run(policy)) {
+		t.Fatal("faults differ for the same seed")
+	}
+	var dropped int
+	for _, err := range errs {
+		if err == ErrMsgDropped {
+			dropped++
+		}
+	}
+	if dropped == 0 || dropped == len(errs) {
+		t.Fatalf("unexpected number of dropped messages: %d", dropped)
+	}
+
+	// Direction filter.
+	policy.Outbound = false
+	for _, err := range run(policy) {
+		if err != nil {
+			t.Fatal("fault injected into disabled direction")
+		}
+	}
+}
+
+func TestFaultHookCorrupt(t *testing.T) {
+	var (
+		rw1, rw2 = MsgPipe()
+		hook     = NewFaultHook(FaultPolicy{Seed: 2, Inbound: true, CorruptRate: 1})
+		w        = WrapMsgReadWriter(rw2, hook)
+	)
+	defer rw1.Close()
+
+	go Send(rw1, 0, []byte{1, 2, 3, 4})
+	msg, err := w.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	if err := msg.Decode(&got); err == nil && reflect.DeepEqual(got, []byte{1, 2, 3, 4}) {
+		t.Fatal("message not corrupted")
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"net"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common/mclock"
@@ -35,7 +36,8 @@ import (
 )
 
 var (
-	ErrShuttingDown = errors.New("shutting down")
+	ErrShuttingDown   = errors.New("shutting down")
+	ErrWriteQueueFull = errors.New("write queue full")
 )
 
 const (
@@ -45,7 +47,17 @@ const (
 
 	snappyProtocolVersion = 5
 
-	pingInterval = 15 * time.Second
+	defaultPingInterval = 15 * time.Second
+
+	// rttWeight is the weight of a new round-trip time sample in the moving average.
+	rttWeight = 0.2
+
+	// maxUnansweredPings is the number of unanswered pings that are timed.
+	maxUnansweredPings = 16
+
+	// drainTimeout is the time given to queued protocol writes to complete
+	// when the peer is disconnected locally.
+	drainTimeout = time.Second
 )
 
 const (
@@ -87,14 +99,27 @@ const (
 	// PeerEventTypeMsgRecv is the type of event emitted when a
 	// message is received from a peer
 	PeerEventTypeMsgRecv PeerEventType = "msgrecv"
+
+	// PeerEventTypeHandshakeFailed is the type of event emitted when a
+	// connection fails the encryption or protocol handshake, or is
+	// rejected by the post-handshake checks
+	PeerEventTypeHandshakeFailed PeerEventType = "handshakefailed"
 )
 
 // PeerEvent is an event emitted when peers are either added or dropped from
-// a p2p.Server or when a message is sent or received on a peer connection
+// a p2p.Server or when a message is sent or received on a peer connection.
+// For drop events, Reason holds the disconnect reason exchanged with the
+// remote side, i.e. the one it sent to us or the one we sent to it, and Duration
+// holds the length of the session. Add events carry the capabilities advertised
+// by the peer.
 type PeerEvent struct {
 	Type          PeerEventType `json:"type"`
 	Peer          enode.ID      `json:"peer"`
 	Error         string        `json:"error,omitempty"`
+	Reason        string        `json:"reason,omitempty"`
+	Duration      time.Duration `json:"duration,omitempty"`
+	Caps          []string      `json:"caps,omitempty"`
+	Inbound       bool          `json:"inbound,omitempty"`
 	Protocol      string        `json:"protocol,omitempty"`
 	MsgCode       *uint64       `json:"msg_code,omitempty"`
 	MsgSize       *uint32       `json:"msg_size,omitempty"`
@@ -114,6 +139,25 @@ type Peer struct {
 	closed   chan struct{}
 	pingRecv chan struct{}
 	disc     chan DiscReason
+	writes   *writeQueue
+	discErr  *DisconnectError // set before closed is closed
+
+	lastMsg int64 // unix time (ns) of the last received message, accessed atomically
+	ended   int64 // unix time (ns) at which the peer shut down, accessed atomically
+
+	msgQueueSize    int  // size of the inbound queue of each protocol
+	dropOnFullQueue bool // disconnect instead of blocking when a queue is full, requires msgQueueSize
+
+	pingInterval time.Duration    // interval between keepalive pings
+	pingMu       sync.Mutex       // protects pingSent
+	pingSent     []mclock.AbsTime // send times of unanswered pings, oldest first
+	rtt          int64            // average round-trip time, accessed atomically
+
+	// reputation tracks the score of the peer, nil if the peer has no server
+	reputation *reputation
+
+	// msgHooks are applied to the message streams of all subprotocols
+	msgHooks []MsgHook
 
 	// events receives message send / receive events if set
 	events   *event.Feed
@@ -191,6 +235,21 @@ func (p *Peer) RunningCap(protocol string, versions []uint) bool {
 	return false
 }
 
+// Traffic returns the number of messages and payload bytes exchanged with the
+// peer, keyed by the name of the running protocol.
+func (p *Peer) Traffic() map[string]TrafficStats {
+	stats := make(map[string]TrafficStats, len(p.running))
+	for name, proto := range p.running {
+		stats[name] = TrafficStats{
+			MsgIn:    atomic.LoadUint64(&proto.traffic.msgIn),
+			MsgOut:   atomic.LoadUint64(&proto.traffic.msgOut),
+			BytesIn:  atomic.LoadUint64(&proto.traffic.bytesIn),
+			BytesOut: atomic.LoadUint64(&proto.traffic.bytesOut),
+		}
+	}
+	return stats
+}
+
 // RemoteAddr returns the remote address of the network connection.
 func (p *Peer) RemoteAddr() net.Addr {
 	return p.rw.fd.RemoteAddr()
@@ -220,6 +279,48 @@ func (p *Peer) String() string {
 	return fmt.Sprintf("Peer %x %v", id[:8], p.RemoteAddr())
 }
 
+// RemoteListenPort returns the TCP listening port advertised by the remote
+// peer in the protocol handshake. It is zero for most current clients.
+func (p *Peer) RemoteListenPort() uint64 {
+	return p.rw.listenPort
+}
+
+// ConnectedAt returns the time at which the connection to the peer was
+// established.
+func (p *Peer) ConnectedAt() time.Time {
+	return p.rw.connectedAt
+}
+
+// HandshakeDuration returns the time taken by the encryption and protocol
+// handshakes.
+func (p *Peer) HandshakeDuration() time.Duration {
+	if p.rw.connectedAt.IsZero() || p.rw.handshakeDone.IsZero() {
+		return 0
+	}
+	return p.rw.handshakeDone.Sub(p.rw.connectedAt)
+}
+
+// LastMessageAt returns the time at which the last message was received from the
+// peer. It returns the zero time if no message has been received.
+func (p *Peer) LastMessageAt() time.Time {
+	if t := atomic.LoadInt64(&p.lastMsg); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// SessionDuration returns the time the peer has been connected. After the peer
+// has disconnected, it returns the total duration of the session.
+func (p *Peer) SessionDuration() time.Duration {
+	if p.rw.connectedAt.IsZero() {
+		return 0
+	}
+	if t := atomic.LoadInt64(&p.ended); t != 0 {
+		return time.Unix(0, t).Sub(p.rw.connectedAt)
+	}
+	return time.Since(p.rw.connectedAt)
+}
+
 // Inbound returns true if the peer is an inbound connection
 func (p *Peer) Inbound() bool {
 	return p.rw.is(inboundConn)
@@ -235,7 +336,10 @@ func newPeer(log log.Logger, conn *conn, protocols []Protocol) *Peer {
 		protoErr: make(chan error, len(protomap)+1), // protocols + pingLoop
 		closed:   make(chan struct{}),
 		pingRecv: make(chan struct{}, 16),
+		writes:   newWriteQueue(maxQueuedWrites),
 		log:      log.New("id", conn.node.ID(), "conn", conn.flags),
+
+		pingInterval: defaultPingInterval,
 	}
 	return p
 }
@@ -244,13 +348,21 @@ func (p *Peer) Log() log.Logger {
 	return p.log
 }
 
-func (p *Peer) run() (remoteRequested bool, err error) {
+func (p *Peer) run() (remoteRequested bool, reason DiscReason, err error) {
 	var (
+		proto      string // subprotocol which caused the disconnect
 		writeStart = make(chan struct{}, 1)
 		writeErr   = make(chan error, 1)
 		readErr    = make(chan error, 1)
-		reason     DiscReason // sent to the peer
 	)
+	// Every protocol gets its own inbound queue, so a protocol which is slow
+	// to read does not hold up the others until its queue is full.
+	for _, proto := range p.running {
+		proto.in = make(chan Msg, p.msgQueueSize)
+	}
+	// Reject oversized messages in the transport, before they are decompressed.
+	p.rw.transport.setMaxMsgSize(p.maxMsgSize)
+
 	p.wg.Add(2)
 	go p.readLoop(readErr)
 	go p.pingLoop()
@@ -275,11 +387,16 @@ loop:
 			if r, ok := err.(DiscReason); ok {
 				remoteRequested = true
 				reason = r
+			} else if _, ok := err.(*peerError); ok {
+				reason = discReasonForError(err)
 			} else {
 				reason = DiscNetworkError
 			}
 			break loop
 		case err = <-p.protoErr:
+			if runErr, ok := err.(*protoRunError); ok {
+				proto, err = runErr.proto, runErr.err
+			}
 			reason = discReasonForError(err)
 			break loop
 		case err = <-p.disc:
@@ -287,30 +404,68 @@ loop:
 			break loop
 		}
 	}
+	// Let writes which were queued before a local disconnect reach the remote
+	// side ahead of the disconnect message. This is pointless if the connection
+	// failed or the remote side is leaving anyway.
+	if reason != DiscNetworkError && !remoteRequested {
+		p.drainWrites(writeStart, writeErr)
+	}
 
+	p.discErr = &DisconnectError{Reason: reason, Remote: remoteRequested, Protocol: proto}
+	atomic.StoreInt64(&p.ended, time.Now().UnixNano())
 	close(p.closed)
 	p.rw.close(reason)
 	p.wg.Wait()
-	return remoteRequested, err
+	return remoteRequested, reason, err
+}
+
+// drainWrites lets queued protocol writes complete. It returns when no writes
+// are pending, when a write fails or after drainTimeout.
+func (p *Peer) drainWrites(writeStart chan<- struct{}, writeErr <-chan error) {
+	timeout := time.NewTimer(drainTimeout)
+	defer timeout.Stop()
+
+	for p.writes.bulkPending() > 0 {
+		select {
+		case err := <-writeErr:
+			if err != nil {
+				return
+			}
+			writeStart <- struct{}{}
+		case <-timeout.C:
+			return
+		}
+	}
+}
+
+// shutdownErr returns the error reported to subprotocols after p.closed has
+// been closed.
+func (p *Peer) shutdownErr() error {
+	return p.discErr
 }
 
 func (p *Peer) pingLoop() {
 	defer p.wg.Done()
 
-	ping := time.NewTimer(pingInterval)
+	ping := time.NewTimer(p.pingInterval)
 	defer ping.Stop()
 
 	for {
 		select {
 		case <-ping.C:
-			if err := SendItems(p.rw, pingMsg); err != nil {
+			p.pingMu.Lock()
+			if len(p.pingSent) < maxUnansweredPings {
+				p.pingSent = append(p.pingSent, mclock.Now())
+			}
+			p.pingMu.Unlock()
+			if err := p.writes.control(func() error { return SendItems(p.rw, pingMsg) }); err != nil {
 				p.protoErr <- err
 				return
 			}
-			ping.Reset(pingInterval)
+			ping.Reset(p.pingInterval)
 
 		case <-p.pingRecv:
-			SendItems(p.rw, pongMsg)
+			p.writes.control(func() error { return SendItems(p.rw, pongMsg) })
 
 		case <-p.closed:
 			return
@@ -327,6 +482,7 @@ func (p *Peer) readLoop(errc chan<- error) {
 			return
 		}
 		msg.ReceivedAt = time.Now()
+		atomic.StoreInt64(&p.lastMsg, msg.ReceivedAt.UnixNano())
 		if err = p.handle(msg); err != nil {
 			errc <- err
 			return
@@ -342,6 +498,9 @@ func (p *Peer) handle(msg Msg) error {
 		case p.pingRecv <- struct{}{}:
 		case <-p.closed:
 		}
+	case msg.Code == pongMsg:
+		msg.Discard()
+		p.updateRTT()
 	case msg.Code == discMsg:
 		// This is the last message. We don't need to discard or
 		// check errors because, the connection will be closed after it.
@@ -355,13 +514,30 @@ func (p *Peer) handle(msg Msg) error {
 		// it's a subprotocol message
 		proto, err := p.getProto(msg.Code)
 		if err != nil {
-			return fmt.Errorf("msg code out of range: %v", msg.Code)
+			return err
+		}
+		if proto.MaxMsgSize > 0 && msg.Size > proto.MaxMsgSize {
+			return newPeerError(errInvalidMsg, "%s message too large: %d > %d", proto.Name, msg.Size, proto.MaxMsgSize)
 		}
+		atomic.AddUint64(&proto.traffic.msgIn, 1)
+		atomic.AddUint64(&proto.traffic.bytesIn, uint64(msg.Size))
 		if metrics.Enabled {
 			m := fmt.Sprintf("%s/%s/%d/%#02x", ingressMeterName, proto.Name, proto.Version, msg.Code-proto.offset)
 			metrics.GetOrRegisterMeter(m, nil).Mark(int64(msg.meterSize))
 			metrics.GetOrRegisterMeter(m+"/packets", nil).Mark(1)
 		}
+		if p.dropOnFullQueue && cap(proto.in) > 0 {
+			select {
+			case proto.in <- msg:
+				return nil
+			case <-p.closed:
+				return io.EOF
+			default:
+				return newPeerError(errMsgQueueFull, "%s", proto.Name)
+			}
+		}
+		// Stop reading until the protocol has caught up. This applies TCP
+		// backpressure to the remote side.
 		select {
 		case proto.in <- msg:
 			return nil
@@ -372,6 +548,58 @@ func (p *Peer) handle(msg Msg) error {
 	return nil
 }
 
+// updateRTT measures the round-trip time of the oldest unanswered ping. Pongs
+// which do not answer a ping are ignored.
+func (p *Peer) updateRTT() {
+	p.pingMu.Lock()
+	if len(p.pingSent) == 0 {
+		p.pingMu.Unlock()
+		return
+	}
+	sent := p.pingSent[0]
+	p.pingSent = p.pingSent[1:]
+	p.pingMu.Unlock()
+
+	sample := time.Duration(mclock.Now() - sent)
+	rtt := time.Duration(atomic.LoadInt64(&p.rtt))
+	if rtt == 0 {
+		rtt = sample
+	} else {
+		rtt += time.Duration(rttWeight * float64(sample-rtt))
+	}
+	atomic.StoreInt64(&p.rtt, int64(rtt))
+	rttHistogram.Update(rtt.Microseconds())
+}
+
+// RTT returns the moving average of the round-trip times measured with keepalive
+// pings. It returns zero if no measurement has been made yet.
+func (p *Peer) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.rtt))
+}
+
+// AdjustScore adds delta to the reputation score of the peer. Subprotocols use
+// it to report good (positive delta) and bad (negative delta) behavior. Scores
+// start at zero and decay toward zero over time. Peers scoring below -50 are no
+// longer dialed from discovery results, and peers reaching -100 are disconnected
+// and banned for an hour. Calls on peers not managed by a Server are ignored.
+func (p *Peer) AdjustScore(delta float64) {
+	if p.reputation == nil {
+		return
+	}
+	if p.reputation.adjust(p.ID(), delta) {
+		p.log.Debug("Peer banned due to low reputation", "score", p.reputation.score(p.ID()))
+		p.Disconnect(DiscUselessPeer)
+	}
+}
+
+// Score returns the current reputation score of the peer.
+func (p *Peer) Score() float64 {
+	if p.reputation == nil {
+		return 0
+	}
+	return p.reputation.score(p.ID())
+}
+
 func countMatchingProtocols(protocols []Protocol, caps []Cap) int {
 	n := 0
 	for _, cap := range caps {
@@ -399,7 +627,7 @@ outer:
 					offset -= old.Length
 				}
 				// Assign the new match
-				result[cap.Name] = &protoRW{Protocol: proto, offset: offset, in: make(chan Msg), w: rw}
+				result[cap.Name] = &protoRW{Protocol: proto, offset: offset, w: rw}
 				offset += proto.Length
 
 				continue outer
@@ -416,25 +644,77 @@ func (p *Peer) startProtocols(writeStart <-chan struct{}, writeErr chan<- error)
 		proto.closed = p.closed
 		proto.wstart = writeStart
 		proto.werr = writeErr
-		var rw MsgReadWriter = proto
+		proto.writes = p.writes
+		proto.shutdownErr = p.shutdownErr
+		rw := WrapMsgReadWriter(proto, p.msgHooks...)
 		if p.events != nil {
 			rw = newMsgEventer(rw, p.events, p.ID(), proto.Name, p.Info().Network.RemoteAddress, p.Info().Network.LocalAddress)
 		}
 		p.log.Trace(fmt.Sprintf("Starting protocol %s/%d", proto.Name, proto.Version))
 		go func() {
 			defer p.wg.Done()
-			err := proto.Run(p, rw)
+			var err error
+			if proto.Handshake != nil {
+				err = p.runProtocolHandshake(proto.Name, proto.Handshake, rw)
+			}
+			if err == nil {
+				err = proto.Run(p, rw)
+			}
 			if err == nil {
 				p.log.Trace(fmt.Sprintf("Protocol %s/%d returned", proto.Name, proto.Version))
 				err = errProtocolReturned
 			} else if !errors.Is(err, io.EOF) {
 				p.log.Trace(fmt.Sprintf("Protocol %s/%d failed", proto.Name, proto.Version), "err", err)
 			}
-			p.protoErr <- err
+			p.protoErr <- &protoRunError{proto.Name, err}
 		}()
 	}
 }
 
+// runProtocolHandshake performs the handshake of a subprotocol. The send and
+// receive goroutines are tracked by p.wg, so Peer.run waits for them even if
+// the handshake times out.
+func (p *Peer) runProtocolHandshake(name string, hs *ProtocolHandshake, rw MsgReadWriter) error {
+	errc := make(chan error, 2)
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		errc <- Send(rw, hs.Code, hs.Local(p))
+	}()
+	go func() {
+		defer p.wg.Done()
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer msg.Discard()
+		if msg.Code != hs.Code {
+			errc <- newPeerError(errInvalidMsg, "expected %s handshake, got code %d", name, msg.Code)
+			return
+		}
+		errc <- hs.Remote(p, msg)
+	}()
+
+	timeout := hs.Timeout
+	if timeout == 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return &ProtocolHandshakeError{Protocol: name, Err: err}
+			}
+		case <-timer.C:
+			return &ProtocolHandshakeError{Protocol: name, Err: DiscReadTimeout}
+		}
+	}
+	return nil
+}
+
 // getProto finds the protocol responsible for handling
 // the given message code.
 func (p *Peer) getProto(code uint64) (*protoRW, error) {
@@ -446,14 +726,44 @@ func (p *Peer) getProto(code uint64) (*protoRW, error) {
 	return nil, newPeerError(errInvalidMsgCode, "%d", code)
 }
 
+// maxMsgSize returns the size limit of messages with the given code. Zero means
+// that only the frame size limit of the transport applies.
+func (p *Peer) maxMsgSize(code uint64) uint32 {
+	if code >= baseProtocolLength {
+		if proto, err := p.getProto(code); err == nil {
+			return proto.MaxMsgSize
+		}
+	}
+	return baseProtocolMaxMsgSize
+}
+
 type protoRW struct {
 	Protocol
-	in     chan Msg        // receives read messages
-	closed <-chan struct{} // receives when peer is shutting down
-	wstart <-chan struct{} // receives when write may start
-	werr   chan<- error    // for write results
-	offset uint64
-	w      MsgWriter
+	in      chan Msg        // receives read messages
+	closed  <-chan struct{} // receives when peer is shutting down
+	wstart  <-chan struct{} // receives when write may start
+	werr    chan<- error    // for write results
+	offset  uint64
+	w       MsgWriter
+	writes  *writeQueue // schedules writes with the base protocol
+	traffic trafficCounters
+
+	shutdownErr func() error // error returned once closed
+}
+
+// trafficCounters counts the messages and payload bytes of a protocol.
+type trafficCounters struct {
+	msgIn, msgOut     uint64 // accessed atomically
+	bytesIn, bytesOut uint64 // accessed atomically
+}
+
+// TrafficStats contains the number of messages and payload bytes exchanged
+// with a peer on a single protocol.
+type TrafficStats struct {
+	MsgIn    uint64 `json:"msgIn"`
+	MsgOut   uint64 `json:"msgOut"`
+	BytesIn  uint64 `json:"bytesIn"`
+	BytesOut uint64 `json:"bytesOut"`
 }
 
 func (rw *protoRW) WriteMsg(msg Msg) (err error) {
@@ -465,16 +775,38 @@ func (rw *protoRW) WriteMsg(msg Msg) (err error) {
 
 	msg.Code += rw.offset
 
+	// Don't start new writes once the peer is shutting down. Writes which
+	// are already in progress complete before the disconnect message is sent.
+	select {
+	case <-rw.closed:
+		return rw.shutdownErr()
+	default:
+	}
+	// Bulk writes are limited to maxQueuedWrites per peer. Once the write
+	// token is received, pending ping/pong writes are allowed to go first.
+	if err := rw.writes.enqueueBulk(); err != nil {
+		return err
+	}
 	select {
 	case <-rw.wstart:
+		rw.writes.waitControl()
+		size := msg.Size
 		err = rw.w.WriteMsg(msg)
+		// Release the slot before reporting the result, so Peer.drainWrites
+		// does not wait for a write which has already completed.
+		rw.writes.dequeueBulk()
+		if err == nil {
+			atomic.AddUint64(&rw.traffic.msgOut, 1)
+			atomic.AddUint64(&rw.traffic.bytesOut, uint64(size))
+		}
 		// Report write status back to Peer.run. It will initiate
 		// shutdown if the error is non-nil and unblock the next write
 		// otherwise. The calling protocol code should exit for errors
 		// as well but we don't want to rely on that.
 		rw.werr <- err
 	case <-rw.closed:
-		err = ErrShuttingDown
+		rw.writes.dequeueBulk()
+		err = rw.shutdownErr()
 	}
 	return err
 }
@@ -485,7 +817,7 @@ func (rw *protoRW) ReadMsg() (Msg, error) {
 		msg.Code -= rw.offset
 		return msg, nil
 	case <-rw.closed:
-		return Msg{}, io.EOF
+		return Msg{}, rw.shutdownErr()
 	}
 }
 
@@ -499,22 +831,30 @@ type PeerInfo struct {
 	Name    string   `json:"name"`          // Name of the node, including client type, version, OS, custom data
 	Caps    []string `json:"caps"`          // Protocols advertised by this peer
 	Network struct {
-		LocalAddress  string `json:"localAddress"`  // Local endpoint of the TCP data connection
-		RemoteAddress string `json:"remoteAddress"` // Remote endpoint of the TCP data connection
-		Inbound       bool   `json:"inbound"`
-		Trusted       bool   `json:"trusted"`
-		Static        bool   `json:"static"`
+		LocalAddress  string        `json:"localAddress"`  // Local endpoint of the TCP data connection
+		RemoteAddress string        `json:"remoteAddress"` // Remote endpoint of the TCP data connection
+		Inbound       bool          `json:"inbound"`
+		Trusted       bool          `json:"trusted"`
+		Static        bool          `json:"static"`
+		ListenPort    uint64        `json:"listenPort,omitempty"` // Listening port advertised by the peer
+		RTT           time.Duration `json:"rtt,omitempty"`        // Smoothed round-trip time of keepalive pings
 	} `json:"network"`
 	Protocols map[string]interface{} `json:"protocols"` // Sub-protocol specific metadata fields
 }
 
-// Info gathers and returns a collection of metadata known about a peer.
-func (p *Peer) Info() *PeerInfo {
-	// Gather the protocol capabilities
+// capNames returns the capabilities of the peer in string form.
+func (p *Peer) capNames() []string {
 	var caps []string
 	for _, cap := range p.Caps() {
 		caps = append(caps, cap.String())
 	}
+	return caps
+}
+
+// Info gathers and returns a collection of metadata known about a peer.
+func (p *Peer) Info() *PeerInfo {
+	caps := p.capNames()
+
 	// Assemble the generic peer metadata
 	info := &PeerInfo{
 		Enode:     p.Node().URLv4(),
@@ -531,6 +871,8 @@ func (p *Peer) Info() *PeerInfo {
 	info.Network.Inbound = p.rw.is(inboundConn)
 	info.Network.Trusted = p.rw.is(trustedConn)
 	info.Network.Static = p.rw.is(staticDialedConn)
+	info.Network.ListenPort = p.rw.listenPort
+	info.Network.RTT = p.RTT()
 
 	// Gather all the running protocol infos
 	for _, proto := range p.running {
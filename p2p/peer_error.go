@@ -19,16 +19,19 @@ package p2p
 import (
 	"errors"
 	"fmt"
+	"io"
 )
 
 const (
 	errInvalidMsgCode = iota
 	errInvalidMsg
+	errMsgQueueFull
 )
 
 var errorToString = map[int]string{
 	errInvalidMsgCode: "invalid message code",
 	errInvalidMsg:     "invalid message",
+	errMsgQueueFull:   "message queue full",
 }
 
 type peerError struct {
@@ -99,10 +102,48 @@ func (d DiscReason) Error() string {
 	return d.String()
 }
 
+// DisconnectError is returned by the MsgReadWriter of a subprotocol once the peer
+// is shutting down. It describes why the connection is being closed.
+//
+// For compatibility with code checking for the errors returned before,
+// errors.Is(err, ErrShuttingDown) and errors.Is(err, io.EOF) hold for it.
+type DisconnectError struct {
+	Reason   DiscReason
+	Remote   bool   // whether the remote peer requested the disconnect
+	Protocol string // name of the subprotocol whose error caused the disconnect, if any
+}
+
+func (e *DisconnectError) Error() string {
+	origin := "local"
+	if e.Remote {
+		origin = "remote"
+	}
+	if e.Protocol != "" {
+		return fmt.Sprintf("peer disconnected (%s, %s, protocol %s)", e.Reason, origin, e.Protocol)
+	}
+	return fmt.Sprintf("peer disconnected (%s, %s)", e.Reason, origin)
+}
+
+// Is reports whether target is ErrShuttingDown or io.EOF.
+func (e *DisconnectError) Is(target error) bool {
+	return target == ErrShuttingDown || target == io.EOF
+}
+
+// protoRunError is sent by a subprotocol goroutine when Run returns.
+type protoRunError struct {
+	proto string
+	err   error
+}
+
+func (e *protoRunError) Error() string { return e.err.Error() }
+
 func discReasonForError(err error) DiscReason {
 	if reason, ok := err.(DiscReason); ok {
 		return reason
 	}
+	if hsErr, ok := err.(*ProtocolHandshakeError); ok {
+		return discReasonForError(hsErr.Err)
+	}
 	if errors.Is(err, errProtocolReturned) {
 		return DiscQuitting
 	}
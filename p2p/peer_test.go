@@ -20,6 +20,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"reflect"
@@ -86,12 +87,25 @@ func newNode(id enode.ID, addr string) *enode.Node {
 }
 
 func testPeer(protos []Protocol) (func(), *conn, *Peer, <-chan error) {
+	return testPeerWithTimeouts(protos, defaultPingInterval, frameReadTimeout)
+}
+
+// testPeerWithTimeouts is like testPeer, but configures the keepalive ping
+// interval and the read timeout of the local peer.
+func testPeerWithTimeouts(protos []Protocol, pingInterval, readTimeout time.Duration) (func(), *conn, *Peer, <-chan error) {
+	return testPeerWithSetup(protos, readTimeout, func(p *Peer) { p.pingInterval = pingInterval })
+}
+
+// testPeerWithSetup is like testPeer, but calls setup on the local peer before
+// running it.
+func testPeerWithSetup(protos []Protocol, readTimeout time.Duration, setup func(*Peer)) (func(), *conn, *Peer, <-chan error) {
 	var (
 		fd1, fd2   = net.Pipe()
 		key1, key2 = newkey(), newkey()
 		t1         = newTestTransport(&key2.PublicKey, fd1, nil)
 		t2         = newTestTransport(&key1.PublicKey, fd2, &key1.PublicKey)
 	)
+	t1.(*testTransport).readTimeout = readTimeout
 
 	c1 := &conn{fd: fd1, node: newNode(uintID(1), ""), transport: t1}
 	c2 := &conn{fd: fd2, node: newNode(uintID(2), ""), transport: t2}
@@ -101,9 +115,10 @@ func testPeer(protos []Protocol) (func(), *conn, *Peer, <-chan error) {
 	}
 
 	peer := newPeer(log.Root(), c1, protos)
+	setup(peer)
 	errc := make(chan error, 1)
 	go func() {
-		_, err := peer.run()
+		_, _, err := peer.run()
 		errc <- err
 	}()
 
@@ -168,6 +183,136 @@ func TestPeerProtoEncodeMsg(t *testing.T) {
 	}
 }
 
+func TestPeerTraffic(t *testing.T) {
+	done := make(chan struct{})
+	proto := Protocol{
+		Name:   "a",
+		Length: 5,
+		Run: func(peer *Peer, rw MsgReadWriter) error {
+			for i := 0; i < 3; i++ {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+			}
+			if err := Send(rw, 1, []byte{1, 2, 3}); err != nil {
+				return err
+			}
+			if err := Send(rw, 2, []byte{}); err != nil {
+				return err
+			}
+			close(done)
+			return <-make(chan error) // block until the peer shuts down
+		},
+	}
+	closer, rw, peer, _ := testPeer([]Protocol{proto})
+	defer closer()
+
+	Send(rw, baseProtocolLength+1, []byte{1})
+	Send(rw, baseProtocolLength+2, make([]byte, 100))
+	Send(rw, baseProtocolLength+3, []byte{})
+	if err := ExpectMsg(rw, baseProtocolLength+1, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExpectMsg(rw, baseProtocolLength+2, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	want := map[string]TrafficStats{
+		"a": {MsgIn: 3, MsgOut: 2, BytesIn: 1 + 102 + 1, BytesOut: 4 + 1},
+	}
+	if have := peer.Traffic(); !reflect.DeepEqual(have, want) {
+		t.Errorf("wrong traffic stats:\nhave %+v\nwant %+v", have, want)
+	}
+}
+
+// statusProtocol returns a protocol with a handshake exchanging version numbers.
+// The protocol reports the result of Run on the given channel.
+func statusProtocol(version uint, timeout time.Duration, ran chan<- uint) Protocol {
+	var remoteVersion uint
+	return Protocol{
+		Name:   "status",
+		Length: 2,
+		Handshake: &ProtocolHandshake{
+			Code:    0,
+			Timeout: timeout,
+			Local:   func(peer *Peer) interface{} { return []uint{version} },
+			Remote: func(peer *Peer, msg Msg) error {
+				var v []uint
+				if err := msg.Decode(&v); err != nil {
+					return err
+				}
+				if len(v) != 1 || v[0] != version {
+					return fmt.Errorf("version mismatch: %v", v)
+				}
+				remoteVersion = v[0]
+				return nil
+			},
+		},
+		Run: func(peer *Peer, rw MsgReadWriter) error {
+			ran <- remoteVersion
+			_, err := rw.ReadMsg()
+			return err
+		},
+	}
+}
+
+func TestPeerProtoHandshake(t *testing.T) {
+	ran := make(chan uint, 1)
+	closer, rw, _, _ := testPeer([]Protocol{statusProtocol(3, 0, ran)})
+	defer closer()
+
+	if err := SendItems(rw, baseProtocolLength, uint(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExpectMsg(rw, baseProtocolLength, []uint{3}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-ran:
+		if v != 3 {
+			t.Errorf("wrong remote version in Run: got %d, want 3", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run not called after handshake")
+	}
+}
+
+func TestPeerProtoHandshakeErrors(t *testing.T) {
+	// Invalid handshake payload.
+	ran := make(chan uint, 1)
+	rw, reasonc := runRLPxTestPeer([]Protocol{statusProtocol(3, 0, ran)})
+	if err := SendItems(rw, baseProtocolLength, uint(4)); err != nil {
+		t.Fatal(err)
+	}
+	expectHandshakeDisconnect(t, rw, reasonc, DiscSubprotocolError)
+	rw.close(errors.New("test done"))
+
+	// Unexpected message instead of the handshake.
+	rw, reasonc = runRLPxTestPeer([]Protocol{statusProtocol(3, 0, ran)})
+	if err := SendItems(rw, baseProtocolLength+1); err != nil {
+		t.Fatal(err)
+	}
+	expectHandshakeDisconnect(t, rw, reasonc, DiscProtocolError)
+	rw.close(errors.New("test done"))
+
+	// Remote never sends its handshake.
+	rw, reasonc = runRLPxTestPeer([]Protocol{statusProtocol(3, 50*time.Millisecond, ran)})
+	if err := ExpectMsg(rw, baseProtocolLength, []uint{3}); err != nil {
+		t.Fatal(err)
+	}
+	expectDisconnect(t, rw, reasonc, DiscReadTimeout)
+	rw.close(errors.New("test done"))
+
+	select {
+	case <-ran:
+		t.Error("Run called after failed handshake")
+	default:
+	}
+}
+
 func TestPeerPing(t *testing.T) {
 	closer, rw, _, _ := testPeer(nil)
 	defer closer()
@@ -179,6 +324,251 @@ func TestPeerPing(t *testing.T) {
 	}
 }
 
+// This test checks that a peer answering keepalive pings slowly is kept
+// with a relaxed read timeout and dropped with a strict one.
+func TestPeerSlowPong(t *testing.T) {
+	const (
+		pingInterval = 50 * time.Millisecond
+		pongDelay    = 250 * time.Millisecond
+	)
+	run := func(readTimeout time.Duration) error {
+		closer, rw, _, errc := testPeerWithTimeouts(nil, pingInterval, readTimeout)
+		defer closer()
+
+		// Answer every ping after a delay.
+		go func() {
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return
+				}
+				msg.Discard()
+				if msg.Code == pingMsg {
+					time.Sleep(pongDelay)
+					if SendItems(rw, pongMsg) != nil {
+						return
+					}
+				}
+			}
+		}()
+		select {
+		case err := <-errc:
+			return err
+		case <-time.After(4 * pongDelay):
+			return nil
+		}
+	}
+	if err := run(4 * pongDelay); err != nil {
+		t.Errorf("peer dropped with relaxed read timeout: %v", err)
+	}
+	if err := run(pongDelay / 2); err == nil {
+		t.Error("peer not dropped with strict read timeout")
+	}
+}
+
+// This test checks that round-trip times are measured from answered pings,
+// while unsolicited pongs are ignored.
+func TestPeerRTT(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	closer, rw, peer, _ := testPeerWithTimeouts(nil, time.Hour, frameReadTimeout)
+	if err := SendItems(rw, pongMsg); err != nil {
+		t.Fatal(err)
+	}
+	// Send a ping to ensure the pong has been handled.
+	if err := SendItems(rw, pingMsg); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExpectMsg(rw, pongMsg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rtt := peer.RTT(); rtt != 0 {
+		t.Errorf("unsolicited pong measured: rtt %v", rtt)
+	}
+	closer()
+
+	closer, rw, peer, _ = testPeerWithTimeouts(nil, 20*time.Millisecond, frameReadTimeout)
+	defer closer()
+	for i := 0; i < 3; i++ {
+		if err := ExpectMsg(rw, pingMsg, nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(delay)
+		if err := SendItems(rw, pongMsg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Wait for the last pong to be handled.
+	if err := ExpectMsg(rw, pingMsg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rtt := peer.RTT(); rtt < delay || rtt > 10*delay {
+		t.Errorf("wrong rtt %v, want about %v", rtt, delay)
+	}
+	if rtt := peer.Info().Network.RTT; rtt < delay {
+		t.Errorf("wrong rtt %v in peer info", rtt)
+	}
+}
+
+func TestPeerLastMessageAt(t *testing.T) {
+	closer, rw, peer, _ := testPeer(nil)
+	defer closer()
+
+	if at := peer.LastMessageAt(); !at.IsZero() {
+		t.Fatalf("last message time set before any message: %v", at)
+	}
+	start := time.Now()
+	if err := SendItems(rw, pingMsg); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExpectMsg(rw, pongMsg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if at := peer.LastMessageAt(); at.Before(start) || at.After(time.Now()) {
+		t.Errorf("wrong last message time %v", at)
+	}
+}
+
+// This test checks that received messages are not queued by default.
+func TestPeerProtoQueueDefault(t *testing.T) {
+	queueCap := make(chan int, 1)
+	proto := Protocol{
+		Name:   "a",
+		Length: 1,
+		Run: func(p *Peer, rw MsgReadWriter) error {
+			queueCap <- cap(rw.(*protoRW).in)
+			<-p.closed
+			return nil
+		},
+	}
+	closer, _, _, _ := testPeer([]Protocol{proto})
+	defer closer()
+
+	if n := <-queueCap; n != 0 {
+		t.Fatalf("inbound queue has capacity %d, want 0", n)
+	}
+}
+
+// This test checks that a protocol which doesn't read its messages does not
+// delay the delivery of messages to other protocols until its queue is full.
+func TestPeerProtoQueue(t *testing.T) {
+	const queueSize = 4
+	delivered := make(chan uint64, 1)
+	protos := []Protocol{
+		{
+			Name:   "slow",
+			Length: 1,
+			Run: func(p *Peer, rw MsgReadWriter) error {
+				<-p.closed
+				return nil
+			},
+		},
+		{
+			Name:   "fast",
+			Length: 1,
+			Run: func(p *Peer, rw MsgReadWriter) error {
+				for {
+					msg, err := rw.ReadMsg()
+					if err != nil {
+						return err
+					}
+					msg.Discard()
+					delivered <- msg.Code
+				}
+			},
+		},
+	}
+	setup := func(p *Peer) { p.msgQueueSize = queueSize }
+
+	t.Run("throttle", func(t *testing.T) {
+		closer, rw, _, _ := testPeerWithSetup(protos, frameReadTimeout, setup)
+		defer closer()
+
+		// Fill the queue of the slow protocol. Protocol codes are assigned in
+		// alphabetical order, so "fast" comes first.
+		go func() {
+			for i := 0; i < queueSize; i++ {
+				if SendItems(rw, baseProtocolLength+1) != nil {
+					return
+				}
+			}
+			SendItems(rw, baseProtocolLength)
+		}()
+		select {
+		case <-delivered:
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered while other queue is backed up")
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		closer, rw, _, errc := testPeerWithSetup(protos, frameReadTimeout, func(p *Peer) {
+			setup(p)
+			p.dropOnFullQueue = true
+		})
+		defer closer()
+
+		go func() {
+			for i := 0; i <= queueSize; i++ {
+				if SendItems(rw, baseProtocolLength+1) != nil {
+					return
+				}
+			}
+		}()
+		select {
+		case err := <-errc:
+			if reason := discReasonForError(err); reason != DiscSubprotocolError {
+				t.Errorf("wrong disconnect reason %v (err %v)", reason, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("peer not disconnected on full queue")
+		}
+	})
+}
+
+// This test checks that dropOnFullQueue does not disconnect peers when messages
+// are not queued, even if the protocol is busy when a message arrives.
+func TestPeerDropOnFullQueueUnbuffered(t *testing.T) {
+	const count = 3
+	delivered := make(chan uint64, count)
+	proto := Protocol{
+		Name:   "a",
+		Length: 1,
+		Run: func(p *Peer, rw MsgReadWriter) error {
+			for {
+				time.Sleep(20 * time.Millisecond)
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+				delivered <- msg.Code
+			}
+		},
+	}
+	closer, rw, _, errc := testPeerWithSetup([]Protocol{proto}, frameReadTimeout, func(p *Peer) {
+		p.dropOnFullQueue = true
+	})
+	defer closer()
+
+	go func() {
+		for i := 0; i < count; i++ {
+			if SendItems(rw, baseProtocolLength) != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < count; i++ {
+		select {
+		case <-delivered:
+		case err := <-errc:
+			t.Fatalf("peer disconnected: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered")
+		}
+	}
+}
+
 // This test checks that a disconnect message sent by a peer is returned
 // as the error from Peer.run.
 func TestPeerDisconnect(t *testing.T) {
@@ -198,6 +588,364 @@ func TestPeerDisconnect(t *testing.T) {
 	}
 }
 
+// runRLPxTestPeer starts a peer which uses the plain RLPx transport on the local
+// side, so disconnect reasons are actually written to the remote end.
+func runRLPxTestPeer(protos []Protocol) (transport, <-chan DiscReason) {
+	var (
+		fd1, fd2   = net.Pipe()
+		key1, key2 = newkey(), newkey()
+		t1         = newTestTransport(&key2.PublicKey, fd1, nil).(*testTransport).rlpxTransport
+		t2         = newTestTransport(&key1.PublicKey, fd2, &key1.PublicKey)
+	)
+	c1 := &conn{fd: fd1, node: newNode(uintID(1), ""), transport: t1}
+	for _, p := range protos {
+		c1.caps = append(c1.caps, p.cap())
+	}
+	peer := newPeer(log.Root(), c1, protos)
+	reasonc := make(chan DiscReason, 1)
+	go func() {
+		_, reason, _ := peer.run()
+		reasonc <- reason
+	}()
+	return t2, reasonc
+}
+
+// expectDisconnect checks that the peer behind rw sends a disconnect message
+// with the given reason and shuts down.
+func expectDisconnect(t *testing.T, rw MsgReadWriter, reasonc <-chan DiscReason, want DiscReason) {
+	t.Helper()
+	if err := ExpectMsg(rw, discMsg, []DiscReason{want}); err != nil {
+		t.Error(err)
+	}
+	select {
+	case reason := <-reasonc:
+		if reason != want {
+			t.Errorf("run returned wrong reason: got %v, want %v", reason, want)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("peer did not return")
+	}
+}
+
+// This test checks that the peer does not return before the handshake callbacks
+// of a timed out protocol handshake have returned.
+func TestPeerProtoHandshakeWait(t *testing.T) {
+	var (
+		called  = make(chan struct{})
+		release = make(chan struct{})
+	)
+	proto := Protocol{
+		Name:   "a",
+		Length: 1,
+		Handshake: &ProtocolHandshake{
+			Code:    0,
+			Timeout: 50 * time.Millisecond,
+			Local:   func(peer *Peer) interface{} { return []uint{1} },
+			Remote: func(peer *Peer, msg Msg) error {
+				close(called)
+				<-release
+				return nil
+			},
+		},
+		Run: func(peer *Peer, rw MsgReadWriter) error { return nil },
+	}
+	closer, rw, _, errc := testPeer([]Protocol{proto})
+	defer closer()
+
+	if err := SendItems(rw, baseProtocolLength, uint(1)); err != nil {
+		t.Fatal(err)
+	}
+	<-called
+	select {
+	case err := <-errc:
+		t.Fatalf("peer returned while handshake still running: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-errc:
+		if !errors.Is(err, DiscReadTimeout) {
+			t.Errorf("wrong error %v, want handshake timeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("peer did not return")
+	}
+}
+
+// expectHandshakeDisconnect is like expectDisconnect, but tolerates a protocol
+// handshake message arriving before the disconnect message.
+func expectHandshakeDisconnect(t *testing.T, rw MsgReadWriter, reasonc <-chan DiscReason, want DiscReason) {
+	t.Helper()
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code == baseProtocolLength {
+		msg.Discard()
+		if msg, err = rw.ReadMsg(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var reason []DiscReason
+	if msg.Code != discMsg {
+		t.Fatalf("expected disconnect, got message code %d", msg.Code)
+	}
+	if err := msg.Decode(&reason); err != nil || len(reason) != 1 || reason[0] != want {
+		t.Errorf("wrong disconnect reason %v (err %v), want %v", reason, err, want)
+	}
+	select {
+	case r := <-reasonc:
+		if r != want {
+			t.Errorf("run returned wrong reason: got %v, want %v", r, want)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("peer did not return")
+	}
+}
+
+// This test checks that a peer sending a message with an invalid code is
+// told the reason for being disconnected.
+func TestPeerDisconnectReason(t *testing.T) {
+	rw, reasonc := runRLPxTestPeer([]Protocol{discard})
+	defer rw.close(errors.New("test done"))
+
+	if err := SendItems(rw, baseProtocolLength+discard.Length); err != nil {
+		t.Fatal(err)
+	}
+	expectDisconnect(t, rw, reasonc, DiscProtocolError)
+}
+
+// This test checks that messages exceeding the protocol's size limit cause
+// a disconnect, while smaller messages are delivered.
+func TestPeerProtoMaxMsgSize(t *testing.T) {
+	delivered := make(chan uint64, 1)
+	proto := Protocol{
+		Name:       "a",
+		Length:     1,
+		MaxMsgSize: 16,
+		Run: func(p *Peer, rw MsgReadWriter) error {
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+				delivered <- msg.Code
+			}
+		},
+	}
+	rw, reasonc := runRLPxTestPeer([]Protocol{proto})
+	defer rw.close(errors.New("test done"))
+
+	if err := SendItems(rw, baseProtocolLength, make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-delivered:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("small message not delivered")
+	}
+	if err := SendItems(rw, baseProtocolLength, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	expectDisconnect(t, rw, reasonc, DiscProtocolError)
+	select {
+	case <-delivered:
+		t.Error("oversized message delivered")
+	default:
+	}
+}
+
+// This test checks that messages of protocols without a size limit are only
+// subject to the frame size limit, while base protocol messages keep their limit.
+func TestPeerProtoDefaultMaxMsgSize(t *testing.T) {
+	delivered := make(chan int, 1)
+	proto := Protocol{
+		Name:   "a",
+		Length: 1,
+		Run: func(p *Peer, rw MsgReadWriter) error {
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+				delivered <- int(msg.Size)
+			}
+		},
+	}
+	rw, reasonc := runRLPxTestPeer([]Protocol{proto})
+	defer rw.close(errors.New("test done"))
+
+	if err := SendItems(rw, baseProtocolLength, make([]byte, 4*baseProtocolMaxMsgSize)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case size := <-delivered:
+		if size <= 4*baseProtocolMaxMsgSize {
+			t.Errorf("delivered message has wrong size %d", size)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("large message not delivered")
+	}
+	if err := SendItems(rw, pongMsg, make([]byte, baseProtocolMaxMsgSize)); err != nil {
+		t.Fatal(err)
+	}
+	expectDisconnect(t, rw, reasonc, DiscProtocolError)
+}
+
+// This test checks that a message written by a protocol before it disconnects
+// the peer is delivered before the disconnect message, and that later writes
+// fail with ErrShuttingDown.
+func TestPeerDisconnectAfterWrite(t *testing.T) {
+	writeErr := make(chan error, 1)
+	proto := Protocol{
+		Name:   "a",
+		Length: 1,
+		Run: func(p *Peer, rw MsgReadWriter) error {
+			if err := SendItems(rw, 0, "goodbye"); err != nil {
+				return err
+			}
+			p.Disconnect(DiscRequested)
+			// Wait for shutdown to begin.
+			for {
+				if _, err := rw.ReadMsg(); err != nil {
+					break
+				}
+			}
+			writeErr <- SendItems(rw, 0, "too late")
+			return nil
+		},
+	}
+	rw, reasonc := runRLPxTestPeer([]Protocol{proto})
+	defer rw.close(errors.New("test done"))
+
+	if err := ExpectMsg(rw, baseProtocolLength, []string{"goodbye"}); err != nil {
+		t.Fatal(err)
+	}
+	expectDisconnect(t, rw, reasonc, DiscRequested)
+	if err := <-writeErr; !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("wrong write error after disconnect: got %v, want %v", err, ErrShuttingDown)
+	}
+}
+
+// This test checks that subprotocols receive a DisconnectError describing the
+// disconnect once the peer shuts down.
+func TestPeerDisconnectError(t *testing.T) {
+	readErr := func(rw MsgReadWriter) error {
+		for {
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				return err
+			}
+			msg.Discard()
+		}
+	}
+	check := func(t *testing.T, err error, want DisconnectError) {
+		t.Helper()
+		var discErr *DisconnectError
+		if !errors.As(err, &discErr) {
+			t.Fatalf("wrong error type %T: %v", err, err)
+		}
+		if *discErr != want {
+			t.Errorf("wrong disconnect error: got %+v, want %+v", *discErr, want)
+		}
+		if !errors.Is(err, ErrShuttingDown) || !errors.Is(err, io.EOF) {
+			t.Error("DisconnectError does not match ErrShuttingDown and io.EOF")
+		}
+	}
+
+	t.Run("remote", func(t *testing.T) {
+		errc := make(chan error, 1)
+		proto := Protocol{
+			Name:   "a",
+			Length: 1,
+			Run: func(p *Peer, rw MsgReadWriter) error {
+				err := readErr(rw)
+				errc <- err
+				return err
+			},
+		}
+		closer, rw, _, _ := testPeer([]Protocol{proto})
+		defer closer()
+		if err := SendItems(rw, discMsg, DiscTooManyPeers); err != nil {
+			t.Fatal(err)
+		}
+		check(t, <-errc, DisconnectError{Reason: DiscTooManyPeers, Remote: true})
+	})
+
+	t.Run("protocol", func(t *testing.T) {
+		errc := make(chan error, 1)
+		protos := []Protocol{
+			{
+				Name:   "a",
+				Length: 1,
+				Run: func(p *Peer, rw MsgReadWriter) error {
+					return newPeerError(errInvalidMsg, "bad message")
+				},
+			},
+			{
+				Name:   "b",
+				Length: 1,
+				Run: func(p *Peer, rw MsgReadWriter) error {
+					err := readErr(rw)
+					errc <- err
+					return err
+				},
+			},
+		}
+		closer, _, _, _ := testPeer(protos)
+		defer closer()
+		check(t, <-errc, DisconnectError{Reason: DiscProtocolError, Protocol: "a"})
+	})
+}
+
+// This test checks that protocol writes which are queued when the peer is
+// disconnected locally are sent before the disconnect message.
+func TestPeerDisconnectDrainWrites(t *testing.T) {
+	const count = 3
+	var (
+		disconnected = make(chan struct{})
+		writeErr     = make(chan error, count)
+	)
+	proto := Protocol{
+		Name:   "a",
+		Length: 1,
+		Run: func(p *Peer, rw MsgReadWriter) error {
+			for i := 0; i < count; i++ {
+				go func(i uint) { writeErr <- SendItems(rw, 0, i) }(uint(i))
+			}
+			for p.writes.bulkPending() < count {
+				time.Sleep(time.Millisecond)
+			}
+			p.Disconnect(DiscRequested)
+			close(disconnected)
+			_, err := rw.ReadMsg()
+			return err
+		},
+	}
+	rw, reasonc := runRLPxTestPeer([]Protocol{proto})
+	defer rw.close(errors.New("test done"))
+
+	<-disconnected
+	for i := 0; i < count; i++ {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Code != baseProtocolLength {
+			t.Fatalf("message %d: got code %d, want queued write", i, msg.Code)
+		}
+		msg.Discard()
+	}
+	expectDisconnect(t, rw, reasonc, DiscRequested)
+	for i := 0; i < count; i++ {
+		if err := <-writeErr; err != nil {
+			t.Errorf("queued write failed: %v", err)
+		}
+	}
+}
+
 // This test is supposed to verify that Peer can reliably handle
 // multiple causes of disconnection occurring at the same time.
 func TestPeerDisconnectRace(t *testing.T) {
@@ -19,6 +19,7 @@ package p2p
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/enr"
@@ -37,6 +38,11 @@ type Protocol struct {
 	// by the protocol.
 	Length uint64
 
+	// MaxMsgSize is the maximum size of a single message of the protocol.
+	// Peers sending larger messages are disconnected before the message is
+	// decompressed. If zero, only the frame size limit of RLPx applies.
+	MaxMsgSize uint32
+
 	// Run is called in a new goroutine when the protocol has been
 	// negotiated with a peer. It should read and write messages from
 	// rw. The Payload for each message must be fully consumed.
@@ -46,6 +52,11 @@ type Protocol struct {
 	// encountered.
 	Run func(peer *Peer, rw MsgReadWriter) error
 
+	// Handshake is an optional status exchange which is performed when the
+	// protocol has been negotiated, before Run is called. Run is not called
+	// if the handshake fails.
+	Handshake *ProtocolHandshake
+
 	// NodeInfo is an optional helper method to retrieve protocol specific metadata
 	// about the host node.
 	NodeInfo func() interface{}
@@ -64,6 +75,40 @@ type Protocol struct {
 	Attributes []enr.Entry
 }
 
+// ProtocolHandshake describes the initial status exchange of a subprotocol.
+// Both sides send their handshake message and wait for the one of the remote
+// side concurrently, so neither can deadlock the other.
+type ProtocolHandshake struct {
+	// Code is the message code of the handshake message.
+	Code uint64
+
+	// Timeout is the maximum time allowed for the exchange.
+	// Zero defaults to 5 seconds.
+	Timeout time.Duration
+
+	// Local returns the handshake payload which is sent to the peer.
+	Local func(peer *Peer) interface{}
+
+	// Remote is called with the handshake message received from the peer.
+	// It should decode and validate the payload. If it returns an error, the
+	// peer is disconnected.
+	Remote func(peer *Peer, msg Msg) error
+}
+
+// ProtocolHandshakeError is returned when a subprotocol handshake fails.
+type ProtocolHandshakeError struct {
+	Protocol string
+	Err      error
+}
+
+func (e *ProtocolHandshakeError) Error() string {
+	return fmt.Sprintf("%s handshake failed: %v", e.Protocol, e.Err)
+}
+
+func (e *ProtocolHandshakeError) Unwrap() error {
+	return e.Err
+}
+
 func (p Protocol) cap() Cap {
 	return Cap{p.Name, p.Version}
 }
@@ -0,0 +1,240 @@
+// Copyright 2026 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	// Scores decay toward zero, halving every scoreHalfLife.
+	scoreHalfLife = 10 * time.Minute
+
+	// Nodes scoring below deprioritizeScore are not dialed from discovery results.
+	deprioritizeScore = -50
+
+	// Nodes reaching banScore are disconnected and rejected for banDuration.
+	banScore    = -100
+	banDuration = time.Hour
+
+	// Score adjustments applied by the p2p layer itself.
+	scoreProtocolError = -25
+	scoreTimeout       = -10
+	scoreUptime        = 1 // per uptimeInterval connected
+	uptimeInterval     = 10 * time.Minute
+	maxUptimeReward    = 10
+
+	// Entries are pruned or evicted when the tracker holds more than this many nodes.
+	maxTrackedScores = 1000
+)
+
+// reputation keeps track of the behavior of remote nodes. Every node has a score
+// which starts at zero, is adjusted by good and bad events and decays toward zero
+// over time. Nodes with a low score are deprioritized when dialing, and nodes
+// whose score reaches banScore are banned for a while.
+type reputation struct {
+	clock  mclock.Clock
+	mu     sync.Mutex
+	scores map[enode.ID]*nodeScore
+}
+
+type nodeScore struct {
+	value       float64
+	updated     mclock.AbsTime
+	bannedUntil mclock.AbsTime
+	explicitBan bool // banned through Server.BanPeer
+}
+
+func newReputation(clock mclock.Clock) *reputation {
+	return &reputation{clock: clock, scores: make(map[enode.ID]*nodeScore)}
+}
+
+// decay applies the decay of the score up to the given time.
+func (s *nodeScore) decay(now mclock.AbsTime) {
+	elapsed := time.Duration(now - s.updated)
+	s.value *= math.Exp2(-float64(elapsed) / float64(scoreHalfLife))
+	s.updated = now
+}
+
+// adjust adds delta to the score of the given node. It returns true if the node
+// has become banned as a result.
+func (r *reputation) adjust(id enode.ID, delta float64) (banned bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	s := r.get(id, now)
+	s.decay(now)
+	s.value += delta
+	if s.value <= banScore && s.bannedUntil <= now {
+		s.bannedUntil = now.Add(banDuration)
+		s.explicitBan = false
+		return true
+	}
+	return false
+}
+
+// ban bans the given node for duration d, regardless of its score. An existing
+// ban which ends later is not shortened.
+func (r *reputation) ban(id enode.ID, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	s := r.get(id, now)
+	if until := now.Add(d); until > s.bannedUntil {
+		s.bannedUntil = until
+	}
+	s.explicitBan = true
+}
+
+// get returns the score entry of the given node, creating it if necessary.
+func (r *reputation) get(id enode.ID, now mclock.AbsTime) *nodeScore {
+	s := r.scores[id]
+	if s == nil {
+		if len(r.scores) >= maxTrackedScores {
+			r.prune(now)
+		}
+		if len(r.scores) >= maxTrackedScores {
+			r.evict(now)
+		}
+		s = &nodeScore{updated: now}
+		r.scores[id] = s
+	}
+	return s
+}
+
+// unban lifts the ban of the given node. A negative score is reset as well, so
+// the node is not banned again right away.
+func (r *reputation) unban(id enode.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s := r.scores[id]; s != nil {
+		s.bannedUntil = 0
+		s.explicitBan = false
+		if s.value < 0 {
+			s.value = 0
+		}
+	}
+}
+
+// bans returns the banned nodes and the remaining time of their bans.
+func (r *reputation) bans() map[enode.ID]time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	bans := make(map[enode.ID]time.Duration)
+	for id, s := range r.scores {
+		if s.bannedUntil > now {
+			bans[id] = time.Duration(s.bannedUntil - now)
+		}
+	}
+	return bans
+}
+
+// prune removes nodes which are not banned and have a negligible score.
+func (r *reputation) prune(now mclock.AbsTime) {
+	for id, s := range r.scores {
+		s.decay(now)
+		if s.bannedUntil <= now && math.Abs(s.value) < 1 {
+			delete(r.scores, id)
+		}
+	}
+}
+
+// evict removes the entry which is least useful to keep. Nodes which are not
+// banned are evicted first, starting with the score closest to zero. Among
+// banned nodes, the ban which ends first is evicted. Scores must be decayed
+// up to now.
+func (r *reputation) evict(now mclock.AbsTime) {
+	var (
+		victim enode.ID
+		worst  *nodeScore
+	)
+	for id, s := range r.scores {
+		if worst == nil || s.evictBefore(worst, now) {
+			victim, worst = id, s
+		}
+	}
+	delete(r.scores, victim)
+}
+
+// evictBefore reports whether s should be evicted before o.
+func (s *nodeScore) evictBefore(o *nodeScore, now mclock.AbsTime) bool {
+	sBanned, oBanned := s.bannedUntil > now, o.bannedUntil > now
+	switch {
+	case sBanned != oBanned:
+		return oBanned
+	case sBanned:
+		return s.bannedUntil < o.bannedUntil
+	default:
+		return math.Abs(s.value) < math.Abs(o.value)
+	}
+}
+
+// score returns the current score of the given node.
+func (r *reputation) score(id enode.ID) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.scores[id]
+	if s == nil {
+		return 0
+	}
+	s.decay(r.clock.Now())
+	return s.value
+}
+
+// banned reports whether the given node is currently banned.
+func (r *reputation) banned(id enode.ID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.scores[id]
+	return s != nil && s.bannedUntil > r.clock.Now()
+}
+
+// explicitBanExpiry returns the time at which the explicit ban of the given node
+// ends. It returns zero if the node is not explicitly banned.
+func (r *reputation) explicitBanExpiry(id enode.ID) mclock.AbsTime {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.scores[id]
+	if s == nil || !s.explicitBan || s.bannedUntil <= r.clock.Now() {
+		return 0
+	}
+	return s.bannedUntil
+}
+
+// deprioritized reports whether the given node should not be dialed unless
+// explicitly requested.
+func (r *reputation) deprioritized(id enode.ID) bool {
+	return r.banned(id) || r.score(id) < deprioritizeScore
+}
+
+// uptimeReward returns the score reward for staying connected for the given time.
+func uptimeReward(d time.Duration) float64 {
+	return math.Min(float64(d/uptimeInterval)*scoreUptime, maxUptimeReward)
+}
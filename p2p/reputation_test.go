@@ -0,0 +1,265 @@
+// Copyright 2026 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestReputationDecay(t *testing.T) {
+	var (
+		clock mclock.Simulated
+		r     = newReputation(&clock)
+		id    = uintID(1)
+	)
+	r.adjust(id, -60)
+	if !r.deprioritized(id) {
+		t.Fatal("node not deprioritized at score -60")
+	}
+	clock.Run(scoreHalfLife)
+	if s := r.score(id); math.Abs(s+30) > 0.001 {
+		t.Fatalf("wrong score after one half-life: got %f, want -30", s)
+	}
+	if r.deprioritized(id) {
+		t.Fatal("node still deprioritized at score -30")
+	}
+	if r.banned(id) {
+		t.Fatal("node banned without reaching the ban threshold")
+	}
+}
+
+func TestReputationBan(t *testing.T) {
+	var (
+		clock mclock.Simulated
+		r     = newReputation(&clock)
+		id    = uintID(1)
+	)
+	if r.adjust(id, banScore/2) {
+		t.Fatal("node banned at half the ban threshold")
+	}
+	if !r.adjust(id, banScore/2) {
+		t.Fatal("node not banned at the ban threshold")
+	}
+	if r.adjust(id, banScore) {
+		t.Fatal("ban reported twice")
+	}
+
+	// The ban holds even though the score recovers.
+	clock.Run(banDuration / 2)
+	r.adjust(id, -banScore)
+	if !r.banned(id) {
+		t.Fatal("ban lifted before ban duration")
+	}
+	clock.Run(banDuration / 2)
+	if r.banned(id) {
+		t.Fatal("ban not lifted after ban duration")
+	}
+}
+
+func TestReputationExplicitBan(t *testing.T) {
+	var (
+		clock mclock.Simulated
+		r     = newReputation(&clock)
+		id    = uintID(1)
+	)
+	r.ban(id, time.Minute)
+	if !r.banned(id) || r.explicitBanExpiry(id) != mclock.AbsTime(time.Minute) {
+		t.Fatal("node not banned")
+	}
+	if bans := r.bans(); len(bans) != 1 || bans[id] != time.Minute {
+		t.Fatalf("wrong ban list %v", bans)
+	}
+	clock.Run(time.Minute)
+	if r.banned(id) || r.explicitBanExpiry(id) != 0 || len(r.bans()) != 0 {
+		t.Fatal("ban not lifted after expiry")
+	}
+
+	// Unbanning also resets a negative score.
+	r.adjust(id, banScore)
+	r.unban(id)
+	if r.banned(id) || r.score(id) != 0 {
+		t.Fatalf("unban failed: banned %t, score %f", r.banned(id), r.score(id))
+	}
+
+	// A shorter ban does not cut an active ban short.
+	r.adjust(id, banScore)
+	r.ban(id, time.Minute)
+	if want := clock.Now().Add(banDuration); r.explicitBanExpiry(id) != want {
+		t.Fatalf("ban shortened: expiry %v, want %v", r.explicitBanExpiry(id), want)
+	}
+}
+
+func TestReputationBanLimit(t *testing.T) {
+	var (
+		clock mclock.Simulated
+		r     = newReputation(&clock)
+	)
+	for i := 0; i < maxTrackedScores; i++ {
+		r.adjust(uintID(uint16(i)), 0.5)
+	}
+	r.ban(uintID(maxTrackedScores), time.Minute)
+	if len(r.scores) > maxTrackedScores {
+		t.Fatalf("tracked scores not pruned: %d entries", len(r.scores))
+	}
+	if !r.banned(uintID(maxTrackedScores)) {
+		t.Fatal("node not banned")
+	}
+}
+
+// This test checks that the tracker stays bounded when no entry can be pruned,
+// evicting unbanned nodes before banned ones.
+func TestReputationEvict(t *testing.T) {
+	var (
+		clock mclock.Simulated
+		r     = newReputation(&clock)
+	)
+	for i := 0; i < maxTrackedScores; i++ {
+		r.adjust(uintID(uint16(i)), -10)
+	}
+	r.adjust(uintID(0), -10)
+	r.adjust(uintID(maxTrackedScores), -30)
+	if len(r.scores) != maxTrackedScores {
+		t.Fatalf("tracked scores not bounded: %d entries", len(r.scores))
+	}
+	if r.score(uintID(0)) == 0 || r.score(uintID(maxTrackedScores)) == 0 {
+		t.Fatal("evicted node with the highest score")
+	}
+
+	// Fill the tracker with banned nodes. Banning another node evicts the
+	// ban ending first.
+	for i := 0; i < maxTrackedScores; i++ {
+		r.ban(uintID(uint16(i)), time.Hour+time.Duration(i)*time.Second)
+	}
+	r.ban(uintID(maxTrackedScores), 2*time.Hour)
+	if len(r.scores) != maxTrackedScores {
+		t.Fatalf("tracked scores not bounded: %d entries", len(r.scores))
+	}
+	if r.banned(uintID(0)) {
+		t.Error("ban ending first not evicted")
+	}
+	if !r.banned(uintID(1)) || !r.banned(uintID(maxTrackedScores)) {
+		t.Error("wrong ban evicted")
+	}
+}
+
+// This test checks that banned nodes are rejected unless they are trusted or
+// static nodes.
+func TestServerBannedPeer(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+	srv := &Server{
+		Config:     Config{MaxPeers: 10},
+		localnode:  enode.NewLocalNode(db, newkey()),
+		reputation: newReputation(mclock.System{}),
+	}
+	node := newNode(uintID(1), "")
+	srv.reputation.adjust(node.ID(), banScore)
+
+	for _, test := range []struct {
+		flags connFlag
+		want  error
+	}{
+		{inboundConn, DiscUselessPeer},
+		{dynDialedConn, DiscUselessPeer},
+		{inboundConn | trustedConn, nil},
+		{staticDialedConn, nil},
+	} {
+		c := &conn{node: node, flags: test.flags}
+		if err := srv.postHandshakeChecks(nil, 0, c); err != test.want {
+			t.Errorf("%v connection: got %v, want %v", test.flags, err, test.want)
+		}
+	}
+
+	// Explicit bans apply to all connections.
+	srv.reputation.ban(node.ID(), time.Hour)
+	for _, flags := range []connFlag{inboundConn | trustedConn, staticDialedConn} {
+		c := &conn{node: node, flags: flags}
+		if err := srv.postHandshakeChecks(nil, 0, c); err != DiscUselessPeer {
+			t.Errorf("%v connection: got %v, want %v", flags, err, DiscUselessPeer)
+		}
+	}
+}
+
+// This test checks that only disconnect reasons decided locally lower the score
+// of a peer.
+func TestServerUpdateScore(t *testing.T) {
+	var clock mclock.Simulated
+	srv := &Server{Config: Config{clock: &clock}, reputation: newReputation(&clock)}
+	for i, test := range []struct {
+		remote bool
+		reason DiscReason
+		want   float64
+	}{
+		{false, DiscProtocolError, scoreProtocolError},
+		{false, DiscReadTimeout, scoreTimeout},
+		{true, DiscProtocolError, 0},
+		{true, DiscReadTimeout, 0},
+	} {
+		p := NewPeer(uintID(uint16(i)), "test", nil)
+		p.created = clock.Now()
+		srv.updateScore(p, test.remote, test.reason, test.reason)
+		if s := srv.PeerScore(p.ID()); math.Abs(s-test.want) > 0.001 {
+			t.Errorf("remote %t, reason %v: got score %f, want %f", test.remote, test.reason, s, test.want)
+		}
+	}
+
+	// Uptime is measured with the server clock.
+	p := NewPeer(uintID(100), "test", nil)
+	p.created = clock.Now()
+	clock.Run(3 * uptimeInterval)
+	srv.updateScore(p, true, DiscRequested, DiscRequested)
+	if s, want := srv.PeerScore(p.ID()), float64(3*scoreUptime); math.Abs(s-want) > 0.001 {
+		t.Errorf("uptime reward: got score %f, want %f", s, want)
+	}
+}
+
+// This test checks that a peer is disconnected when a subprotocol reports
+// enough bad behavior.
+func TestPeerAdjustScore(t *testing.T) {
+	ready := make(chan struct{})
+	proto := Protocol{
+		Name:   "a",
+		Length: 1,
+		Run: func(p *Peer, rw MsgReadWriter) error {
+			<-ready
+			p.AdjustScore(banScore)
+			<-p.closed
+			return nil
+		},
+	}
+	closer, _, p, errc := testPeer([]Protocol{proto})
+	defer closer()
+	p.reputation = newReputation(mclock.System{})
+	close(ready)
+
+	select {
+	case err := <-errc:
+		if err != DiscUselessPeer {
+			t.Errorf("wrong disconnect error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("peer not disconnected")
+	}
+	if !p.reputation.banned(p.ID()) {
+		t.Errorf("peer not banned, score %f", p.Score())
+	}
+}
@@ -55,6 +55,10 @@ type Conn struct {
 	// Compression is enabled if they are non-nil.
 	snappyReadBuffer  []byte
 	snappyWriteBuffer []byte
+
+	// sizeLimit returns the maximum size of messages with the given code.
+	// Only the frame size limit applies if it is nil.
+	sizeLimit func(code uint64) int
 }
 
 // sessionState contains the session keys.
@@ -115,6 +119,14 @@ func (c *Conn) SetReadDeadline(time time.Time) error {
 	return c.conn.SetReadDeadline(time)
 }
 
+// SetSizeLimit sets a function returning the maximum size of messages with the
+// given code. Read rejects larger messages with ErrMessageTooLarge before they
+// are decompressed. A limit of zero means that only the frame size limit
+// applies. It must not be called concurrently with Read.
+func (c *Conn) SetSizeLimit(limit func(code uint64) int) {
+	c.sizeLimit = limit
+}
+
 // SetWriteDeadline sets the deadline for all future write operations.
 func (c *Conn) SetWriteDeadline(time time.Time) error {
 	return c.conn.SetWriteDeadline(time)
@@ -142,9 +154,9 @@ func (c *Conn) Read() (code uint64, data []byte, wireSize int, err error) {
 	}
 	wireSize = len(data)
 
-	// If snappy is enabled, verify and decompress message.
+	// If snappy is enabled, verify the message size before decompressing.
+	actualSize := len(data)
 	if c.snappyReadBuffer != nil {
-		var actualSize int
 		actualSize, err = snappy.DecodedLen(data)
 		if err != nil {
 			return code, nil, 0, err
@@ -152,6 +164,13 @@ func (c *Conn) Read() (code uint64, data []byte, wireSize int, err error) {
 		if actualSize > maxUint24 {
 			return code, nil, 0, errPlainMessageTooLarge
 		}
+	}
+	if c.sizeLimit != nil {
+		if limit := c.sizeLimit(code); limit > 0 && actualSize > limit {
+			return code, nil, 0, fmt.Errorf("%w: code %d, size %d > %d", ErrMessageTooLarge, code, actualSize, limit)
+		}
+	}
+	if c.snappyReadBuffer != nil {
 		c.snappyReadBuffer = growslice(c.snappyReadBuffer, actualSize)
 		data, err = snappy.Decode(c.snappyReadBuffer, data)
 	}
@@ -364,6 +383,10 @@ var (
 	// errPlainMessageTooLarge is returned if a decompressed message length exceeds
 	// the allowed 24 bits (i.e. length >= 16MB).
 	errPlainMessageTooLarge = errors.New("message length >= 16MB")
+
+	// ErrMessageTooLarge is returned by Read if a message exceeds the limit set
+	// with SetSizeLimit.
+	ErrMessageTooLarge = errors.New("message too large")
 )
 
 // Secrets represents the connection secrets which are negotiated during the handshake.
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -64,6 +65,34 @@ func TestReadWriteMsg(t *testing.T) {
 	checkMsgReadWrite(t, peer1, peer2, testCode, testData)
 }
 
+// This test checks that messages exceeding the size limit are rejected before
+// they are decompressed.
+func TestReadSizeLimit(t *testing.T) {
+	peer1, peer2 := createPeers(t)
+	defer peer1.Close()
+	defer peer2.Close()
+	peer1.SetSnappy(true)
+	peer2.SetSnappy(true)
+	peer1.SetSizeLimit(func(code uint64) int { return 64 })
+
+	errc := make(chan error, 1)
+	go func() {
+		_, _, _, err := peer1.Read()
+		errc <- err
+	}()
+	// The zero-filled payload compresses far below the limit.
+	if _, err := peer2.Write(1, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("wrong error: %v", err)
+	}
+	if cap(peer1.snappyReadBuffer) != 0 {
+		t.Fatal("oversized message was decompressed")
+	}
+	checkMsgReadWrite(t, peer1, peer2, 1, make([]byte, 64))
+}
+
 func checkMsgReadWrite(t *testing.T, p1, p2 *Conn, msgCode uint64, msgData []byte) {
 	// Set up the reader.
 	ch := make(chan message, 1)
@@ -62,12 +62,16 @@ const (
 
 	// Maximum amount of time allowed for writing a complete message.
 	frameWriteTimeout = 20 * time.Second
+
+	// Lower bound for the configurable keepalive ping interval.
+	minPingInterval = 1 * time.Second
 )
 
 var (
 	errServerStopped       = errors.New("server stopped")
 	errEncHandshakeError   = errors.New("rlpx enc error")
 	errProtoHandshakeError = errors.New("rlpx proto error")
+	errHandshakeTimeout    = errors.New("handshake timeout")
 )
 
 // Config holds Server options.
@@ -89,6 +93,14 @@ type Config struct {
 	// Setting DialRatio to zero defaults it to 3.
 	DialRatio int `toml:",omitempty"`
 
+	// MaxPeersPerIP limits the number of inbound connections from a single IP
+	// address. Trusted peers are exempt. Zero means no limit.
+	MaxPeersPerIP int `toml:",omitempty"`
+
+	// MaxPeersPerSubnet limits the number of inbound connections from a single
+	// IPv4 /24 subnet. Trusted peers are exempt. Zero means no limit.
+	MaxPeersPerSubnet int `toml:",omitempty"`
+
 	// NoDiscovery can be used to disable the peer discovery mechanism.
 	// Disabling is useful for protocol debugging (manual topology).
 	NoDiscovery bool
@@ -162,6 +174,34 @@ type Config struct {
 	// whenever a message is sent to or received from a peer
 	EnableMsgEvents bool
 
+	// MsgQueueSize is the number of received messages which can be queued for
+	// each protocol of a peer. By default, messages are handed to the protocol
+	// one at a time. Every queued message may be as large as the protocol's
+	// message size limit, so larger queues increase the memory held per peer.
+	MsgQueueSize int `toml:",omitempty"`
+
+	// If DropOnFullQueue is set, peers are disconnected when the message queue
+	// of a protocol is full. By default, reading from the peer is paused until
+	// the protocol catches up. It has no effect unless MsgQueueSize is set.
+	DropOnFullQueue bool `toml:",omitempty"`
+
+	// MsgHooks are run for all messages exchanged by subprotocols. They are
+	// applied in order, see WrapMsgReadWriter.
+	MsgHooks []MsgHook `toml:"-"`
+
+	// PingInterval is the interval at which keepalive pings are sent to
+	// connected peers. Zero defaults to 15 seconds.
+	PingInterval time.Duration `toml:",omitempty"`
+
+	// ReadTimeout is the maximum time allowed for reading a complete message.
+	// Peers which stay silent for longer are disconnected. It must be larger
+	// than PingInterval. Zero defaults to 30 seconds.
+	ReadTimeout time.Duration `toml:",omitempty"`
+
+	// HandshakeTimeout is the maximum time allowed for each of the encryption
+	// and protocol handshakes. Zero defaults to 5 seconds.
+	HandshakeTimeout time.Duration `toml:",omitempty"`
+
 	// Logger is a custom logger to use with the p2p.Server.
 	Logger log.Logger `toml:",omitempty"`
 
@@ -188,12 +228,13 @@ type Server struct {
 	peerFeed     event.Feed
 	log          log.Logger
 
-	nodedb    *enode.DB
-	localnode *enode.LocalNode
-	ntab      *discover.UDPv4
-	DiscV5    *discover.UDPv5
-	discmix   *enode.FairMix
-	dialsched *dialScheduler
+	nodedb     *enode.DB
+	localnode  *enode.LocalNode
+	ntab       *discover.UDPv4
+	DiscV5     *discover.UDPv5
+	discmix    *enode.FairMix
+	dialsched  *dialScheduler
+	reputation *reputation
 
 	// This is read by the NAT port mapping loop.
 	portMappingRegister chan *portMapping
@@ -217,6 +258,7 @@ type peerOpFunc func(map[enode.ID]*Peer)
 type peerDrop struct {
 	*Peer
 	err       error
+	reason    DiscReason
 	requested bool // true if signaled by the peer
 }
 
@@ -239,6 +281,11 @@ type conn struct {
 	cont  chan error // The run loop uses cont to signal errors to SetupConn.
 	caps  []Cap      // valid after the protocol handshake
 	name  string     // valid after the protocol handshake
+
+	listenPort uint64 // advertised in the protocol handshake
+
+	connectedAt   time.Time // when the TCP connection was established
+	handshakeDone time.Time // when the protocol handshake completed
 }
 
 type transport interface {
@@ -249,6 +296,10 @@ type transport interface {
 	// handshake has completed. The code uses conn.id to track this
 	// by setting it to a non-nil value after the encryption handshake.
 	MsgReadWriter
+	// setMaxMsgSize sets the size limit of received messages, by message
+	// code. It is called before the first message is read after the
+	// handshakes.
+	setMaxMsgSize(limit func(code uint64) uint32)
 	// transports must provide Close because we use MsgPipe in some of
 	// the tests. Closing the actual network connection doesn't do
 	// anything in those tests because MsgPipe doesn't use it.
@@ -366,6 +417,38 @@ func (srv *Server) RemovePeer(node *enode.Node) {
 	}
 }
 
+// BanPeer disconnects the given node and refuses connections to and from it for
+// duration d. Unlike bans caused by a low reputation score, explicit bans also
+// apply to trusted and static nodes.
+func (srv *Server) BanPeer(id enode.ID, d time.Duration) {
+	if srv.reputation == nil {
+		return
+	}
+	srv.reputation.ban(id, d)
+	srv.doPeerOp(func(peers map[enode.ID]*Peer) {
+		if peer := peers[id]; peer != nil {
+			peer.Disconnect(DiscUselessPeer)
+		}
+	})
+}
+
+// UnbanPeer lifts the ban of the given node.
+func (srv *Server) UnbanPeer(id enode.ID) {
+	if srv.reputation == nil {
+		return
+	}
+	srv.reputation.unban(id)
+}
+
+// BannedPeers returns the currently banned nodes and the remaining time of
+// their bans.
+func (srv *Server) BannedPeers() map[enode.ID]time.Duration {
+	if srv.reputation == nil {
+		return nil
+	}
+	return srv.reputation.bans()
+}
+
 // AddTrustedPeer adds the given node to a reserved trusted list which allows the
 // node to always connect, even if the slot are full.
 func (srv *Server) AddTrustedPeer(node *enode.Node) {
@@ -468,12 +551,29 @@ func (srv *Server) Start() (err error) {
 	if srv.PrivateKey == nil {
 		return errors.New("Server.PrivateKey must be set to a non-nil key")
 	}
+	if srv.PingInterval != 0 && srv.PingInterval < minPingInterval {
+		return fmt.Errorf("Server.PingInterval must be at least %v", minPingInterval)
+	}
+	if srv.pingInterval() >= srv.readTimeout() {
+		return errors.New("Server.ReadTimeout must be larger than Server.PingInterval")
+	}
+	if srv.HandshakeTimeout < 0 {
+		return errors.New("Server.HandshakeTimeout must not be negative")
+	}
 	if srv.newTransport == nil {
-		srv.newTransport = newRLPX
+		readTimeout := srv.readTimeout()
+		handshakeTimeout := srv.handshakeTimeout()
+		srv.newTransport = func(fd net.Conn, dialDest *ecdsa.PublicKey) transport {
+			t := newRLPX(fd, dialDest).(*rlpxTransport)
+			t.readTimeout = readTimeout
+			t.handshakeTimeout = handshakeTimeout
+			return t
+		}
 	}
 	if srv.listenFunc == nil {
 		srv.listenFunc = net.Listen
 	}
+	srv.reputation = newReputation(srv.clock)
 	srv.quit = make(chan struct{})
 	srv.delpeer = make(chan peerDrop)
 	srv.checkpointPostHandshake = make(chan *conn)
@@ -601,6 +701,8 @@ func (srv *Server) setupDialScheduler() {
 		netRestrict:    srv.NetRestrict,
 		dialer:         srv.Dialer,
 		clock:          srv.clock,
+		deprioritized:  srv.reputation.deprioritized,
+		bannedUntil:    srv.reputation.explicitBanExpiry,
 	}
 	if srv.ntab != nil {
 		config.resolver = srv.ntab
@@ -614,6 +716,27 @@ func (srv *Server) setupDialScheduler() {
 	}
 }
 
+func (srv *Server) pingInterval() time.Duration {
+	if srv.PingInterval == 0 {
+		return defaultPingInterval
+	}
+	return srv.PingInterval
+}
+
+func (srv *Server) readTimeout() time.Duration {
+	if srv.ReadTimeout == 0 {
+		return frameReadTimeout
+	}
+	return srv.ReadTimeout
+}
+
+func (srv *Server) handshakeTimeout() time.Duration {
+	if srv.HandshakeTimeout == 0 {
+		return defaultHandshakeTimeout
+	}
+	return srv.HandshakeTimeout
+}
+
 func (srv *Server) maxInboundConns() int {
 	return srv.MaxPeers - srv.maxDialedConns()
 }
@@ -782,9 +905,9 @@ running:
 
 		case pd := <-srv.delpeer:
 			// A peer disconnected.
-			d := common.PrettyDuration(mclock.Now() - pd.created)
+			d := common.PrettyDuration(pd.SessionDuration())
 			delete(peers, pd.ID())
-			srv.log.Debug("Removing p2p peer", "peercount", len(peers), "id", pd.ID(), "duration", d, "req", pd.requested, "err", pd.err)
+			srv.log.Debug("Removing p2p peer", "peercount", len(peers), "id", pd.ID(), "duration", d, "reason", pd.reason, "req", pd.requested, "err", pd.err)
 			srv.dialsched.peerRemoved(pd.rw)
 			if pd.Inbound() {
 				inboundCount--
@@ -825,6 +948,12 @@ func (srv *Server) postHandshakeChecks(peers map[enode.ID]*Peer, inboundCount in
 		return DiscTooManyPeers
 	case !c.is(trustedConn) && c.is(inboundConn) && inboundCount >= srv.maxInboundConns():
 		return DiscTooManyPeers
+	case !c.is(trustedConn) && c.is(inboundConn) && srv.inboundIPLimitReached(peers, c):
+		return DiscTooManyPeers
+	case srv.reputation != nil && srv.reputation.explicitBanExpiry(c.node.ID()) != 0:
+		return DiscUselessPeer
+	case srv.reputation != nil && !c.is(trustedConn|staticDialedConn) && srv.reputation.banned(c.node.ID()):
+		return DiscUselessPeer
 	case peers[c.node.ID()] != nil:
 		return DiscAlreadyConnected
 	case c.node.ID() == srv.localnode.ID():
@@ -834,6 +963,43 @@ func (srv *Server) postHandshakeChecks(peers map[enode.ID]*Peer, inboundCount in
 	}
 }
 
+// inboundIPLimitReached reports whether the inbound peers connected from the
+// address of c, or from its /24 subnet, have reached the configured limits.
+func (srv *Server) inboundIPLimitReached(peers map[enode.ID]*Peer, c *conn) bool {
+	if srv.MaxPeersPerIP == 0 && srv.MaxPeersPerSubnet == 0 {
+		return false
+	}
+	ip := netutil.AddrIP(c.fd.RemoteAddr())
+	if ip == nil {
+		return false
+	}
+	var subnet *net.IPNet
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(24, 32)
+		subnet = &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	var sameIP, sameSubnet int
+	for _, p := range peers {
+		if !p.Inbound() {
+			continue
+		}
+		pip := netutil.AddrIP(p.RemoteAddr())
+		if pip == nil {
+			continue
+		}
+		if pip.Equal(ip) {
+			sameIP++
+		}
+		if subnet != nil && subnet.Contains(pip) {
+			sameSubnet++
+		}
+	}
+	if srv.MaxPeersPerIP > 0 && sameIP >= srv.MaxPeersPerIP {
+		return true
+	}
+	return srv.MaxPeersPerSubnet > 0 && subnet != nil && sameSubnet >= srv.MaxPeersPerSubnet
+}
+
 func (srv *Server) addPeerChecks(peers map[enode.ID]*Peer, inboundCount int, c *conn) error {
 	// Drop connections with no matching protocols.
 	if len(srv.Protocols) > 0 && countMatchingProtocols(srv.Protocols, c.caps) == 0 {
@@ -935,7 +1101,7 @@ func (srv *Server) checkInboundConn(remoteIP net.IP) error {
 // as a peer. It returns when the connection has been added as a peer
 // or the handshakes have failed.
 func (srv *Server) SetupConn(fd net.Conn, flags connFlag, dialDest *enode.Node) error {
-	c := &conn{fd: fd, flags: flags, cont: make(chan error)}
+	c := &conn{fd: fd, flags: flags, cont: make(chan error), connectedAt: time.Now()}
 	if dialDest == nil {
 		c.transport = srv.newTransport(fd, nil)
 	} else {
@@ -946,8 +1112,25 @@ func (srv *Server) SetupConn(fd net.Conn, flags connFlag, dialDest *enode.Node)
 	if err != nil {
 		if !c.is(inboundConn) {
 			markDialError(err)
+		} else if errors.Is(err, errHandshakeTimeout) {
+			serveHandshakeTimeout.Mark(1)
 		}
 		c.close(err)
+		if !errors.Is(err, errServerStopped) {
+			ev := &PeerEvent{
+				Type:          PeerEventTypeHandshakeFailed,
+				Error:         err.Error(),
+				RemoteAddress: fd.RemoteAddr().String(),
+				LocalAddress:  fd.LocalAddr().String(),
+			}
+			if c.node != nil {
+				ev.Peer = c.node.ID()
+				if errors.Is(err, errHandshakeTimeout) && srv.reputation != nil {
+					srv.reputation.adjust(c.node.ID(), scoreTimeout)
+				}
+			}
+			srv.peerFeed.Send(ev)
+		}
 	}
 	return err
 }
@@ -975,6 +1158,9 @@ func (srv *Server) setupConn(c *conn, dialDest *enode.Node) error {
 	remotePubkey, err := c.doEncHandshake(srv.PrivateKey)
 	if err != nil {
 		srv.log.Trace("Failed RLPx handshake", "addr", c.fd.RemoteAddr(), "conn", c.flags, "err", err)
+		if errors.Is(err, errHandshakeTimeout) {
+			return err
+		}
 		return fmt.Errorf("%w: %v", errEncHandshakeError, err)
 	}
 	if dialDest != nil {
@@ -993,13 +1179,17 @@ func (srv *Server) setupConn(c *conn, dialDest *enode.Node) error {
 	phs, err := c.doProtoHandshake(srv.ourHandshake)
 	if err != nil {
 		clog.Trace("Failed p2p handshake", "err", err)
+		if errors.Is(err, errHandshakeTimeout) {
+			return err
+		}
 		return fmt.Errorf("%w: %v", errProtoHandshakeError, err)
 	}
 	if id := c.node.ID(); !bytes.Equal(crypto.Keccak256(phs.ID), id[:]) {
 		clog.Trace("Wrong devp2p handshake identity", "phsid", hex.EncodeToString(phs.ID))
 		return DiscUnexpectedIdentity
 	}
-	c.caps, c.name = phs.Caps, phs.Name
+	c.caps, c.name, c.listenPort = phs.Caps, phs.Name, phs.ListenPort
+	c.handshakeDone = time.Now()
 	err = srv.checkpoint(c, srv.checkpointAddPeer)
 	if err != nil {
 		clog.Trace("Rejected peer", "err", err)
@@ -1032,6 +1222,14 @@ func (srv *Server) checkpoint(c *conn, stage chan<- *conn) error {
 
 func (srv *Server) launchPeer(c *conn) *Peer {
 	p := newPeer(srv.log, c, srv.Protocols)
+	p.created = srv.clock.Now()
+	p.pingInterval = srv.pingInterval()
+	p.reputation = srv.reputation
+	p.msgHooks = srv.MsgHooks
+	if srv.MsgQueueSize > 0 {
+		p.msgQueueSize = srv.MsgQueueSize
+	}
+	p.dropOnFullQueue = srv.DropOnFullQueue
 	if srv.EnableMsgEvents {
 		// If message events are enabled, pass the peerFeed
 		// to the peer.
@@ -1051,15 +1249,19 @@ func (srv *Server) runPeer(p *Peer) {
 		Peer:          p.ID(),
 		RemoteAddress: p.RemoteAddr().String(),
 		LocalAddress:  p.LocalAddr().String(),
+		Caps:          p.capNames(),
+		Inbound:       p.Inbound(),
 	})
 
 	// Run the per-peer main loop.
-	remoteRequested, err := p.run()
+	remoteRequested, reason, err := p.run()
+	srv.updateScore(p, remoteRequested, reason, err)
 
 	// Announce disconnect on the main loop to update the peer set.
 	// The main loop waits for existing peers to be sent on srv.delpeer
 	// before returning, so this send should not select on srv.quit.
-	srv.delpeer <- peerDrop{p, err, remoteRequested}
+	srv.delpeer <- peerDrop{p, err, reason, remoteRequested}
+	sessionDurationHistogram.Update(p.SessionDuration().Milliseconds())
 
 	// Broadcast peer drop to external subscribers. This needs to be
 	// after the send to delpeer so subscribers have a consistent view of
@@ -1069,11 +1271,42 @@ func (srv *Server) runPeer(p *Peer) {
 		Type:          PeerEventTypeDrop,
 		Peer:          p.ID(),
 		Error:         err.Error(),
+		Reason:        reason.String(),
+		Duration:      p.SessionDuration(),
 		RemoteAddress: p.RemoteAddr().String(),
 		LocalAddress:  p.LocalAddr().String(),
 	})
 }
 
+// updateScore adjusts the reputation of a peer which has just disconnected.
+// Disconnect reasons sent by the remote side describe our behavior rather than
+// the peer's, so only reasons decided locally are penalized.
+func (srv *Server) updateScore(p *Peer, remoteRequested bool, reason DiscReason, err error) {
+	if srv.reputation == nil {
+		return
+	}
+	delta := uptimeReward(time.Duration(srv.clock.Now() - p.created))
+	switch {
+	case remoteRequested:
+	case reason == DiscProtocolError:
+		delta += scoreProtocolError
+	case reason == DiscReadTimeout || isTimeout(err):
+		delta += scoreTimeout
+	}
+	if delta != 0 {
+		srv.reputation.adjust(p.ID(), delta)
+	}
+}
+
+// PeerScore returns the reputation score of the given node. The score is also
+// tracked for nodes which are not currently connected.
+func (srv *Server) PeerScore(id enode.ID) float64 {
+	if srv.reputation == nil {
+		return 0
+	}
+	return srv.reputation.score(id)
+}
+
 // NodeInfo represents a short summary of the information known about the host.
 type NodeInfo struct {
 	ID    string `json:"id"`    // Unique node identifier (also the encryption key)
@@ -60,7 +60,7 @@ func (c *testTransport) doEncHandshake(prv *ecdsa.PrivateKey) (*ecdsa.PublicKey,
 
 func (c *testTransport) doProtoHandshake(our *protoHandshake) (*protoHandshake, error) {
 	pubkey := crypto.FromECDSAPub(c.rpub)[1:]
-	return &protoHandshake{ID: pubkey, Name: "test"}, nil
+	return &protoHandshake{ID: pubkey, Name: "test", ListenPort: 30303}, nil
 }
 
 func (c *testTransport) close(err error) {
@@ -165,6 +165,9 @@ func TestServerDial(t *testing.T) {
 			if peer.Name() != "test" {
 				t.Errorf("peer has wrong name")
 			}
+			if peer.RemoteListenPort() != 30303 {
+				t.Errorf("peer has wrong listen port %d", peer.RemoteListenPort())
+			}
 			if peer.RemoteAddr().String() != conn.LocalAddr().String() {
 				t.Errorf("peer started with wrong conn: got %v, want %v",
 					peer.RemoteAddr(), conn.LocalAddr())
@@ -243,6 +246,220 @@ func TestServerRemovePeerDisconnect(t *testing.T) {
 	}
 }
 
+// This test checks that BanPeer disconnects a connected peer and prevents it
+// from connecting again until it is unbanned.
+func TestServerBanPeer(t *testing.T) {
+	srv1 := &Server{Config: Config{
+		PrivateKey:  newkey(),
+		MaxPeers:    10,
+		NoDiscovery: true,
+		ListenAddr:  "127.0.0.1:0",
+		Logger:      testlog.Logger(t, log.LvlTrace).New("server", "1"),
+	}}
+	srv2 := &Server{Config: Config{
+		PrivateKey:  newkey(),
+		MaxPeers:    10,
+		NoDiscovery: true,
+		ListenAddr:  "127.0.0.1:0",
+		Logger:      testlog.Logger(t, log.LvlTrace).New("server", "2"),
+	}}
+	srv1.Start()
+	defer srv1.Stop()
+	srv2.Start()
+	defer srv2.Stop()
+
+	for _, srv := range []*Server{srv1, srv2} {
+		s := strings.Split(srv.ListenAddr, ":")
+		if port, err := strconv.Atoi(s[1]); err == nil {
+			srv.localnode.Set(enr.TCP(uint16(port)))
+		}
+	}
+	id := srv2.Self().ID()
+	if !syncAddPeer(srv1, srv2.Self()) {
+		t.Fatal("peer not connected")
+	}
+
+	// Ban while connected.
+	events := make(chan *PeerEvent, 10)
+	sub := srv1.SubscribeEvents(events)
+	srv1.BanPeer(id, time.Hour)
+	for ev := range events {
+		if ev.Type == PeerEventTypeDrop && ev.Peer == id {
+			break
+		}
+	}
+	sub.Unsubscribe()
+	if _, ok := srv1.BannedPeers()[id]; !ok {
+		t.Fatal("peer missing in ban list")
+	}
+
+	// Reconnecting fails while banned, in both directions.
+	srv1.RemovePeer(srv2.Self())
+	if syncAddPeer(srv1, srv2.Self()) {
+		t.Fatal("banned peer dialed")
+	}
+	if syncAddPeer(srv2, srv1.Self()) {
+		t.Fatal("banned peer accepted")
+	}
+
+	// After unbanning, the peer is accepted again.
+	srv1.UnbanPeer(id)
+	if len(srv1.BannedPeers()) != 0 {
+		t.Fatal("ban list not empty after unban")
+	}
+	added := make(chan *PeerEvent, 10)
+	sub2 := srv1.SubscribeEvents(added)
+	defer sub2.Unsubscribe()
+	fd, err := net.Dial("tcp", srv1.ListenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv2.SetupConn(fd, dynDialedConn, srv1.Self()); err != nil {
+		t.Fatal("connection setup failed:", err)
+	}
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-added:
+			if ev.Type == PeerEventTypeAdd && ev.Peer == id {
+				return
+			}
+		case <-timeout:
+			t.Fatal("peer not accepted after unban")
+		}
+	}
+}
+
+// This test checks the connection timestamps of peers and the session duration
+// reported in drop events.
+func TestServerPeerSessionTimes(t *testing.T) {
+	srv1 := &Server{Config: Config{
+		PrivateKey:  newkey(),
+		MaxPeers:    1,
+		NoDiscovery: true,
+		Logger:      testlog.Logger(t, log.LvlTrace).New("server", "1"),
+	}}
+	srv2 := &Server{Config: Config{
+		PrivateKey:  newkey(),
+		MaxPeers:    1,
+		NoDiscovery: true,
+		NoDial:      true,
+		ListenAddr:  "127.0.0.1:0",
+		Logger:      testlog.Logger(t, log.LvlTrace).New("server", "2"),
+	}}
+	srv1.Start()
+	defer srv1.Stop()
+	srv2.Start()
+	defer srv2.Stop()
+
+	s := strings.Split(srv2.ListenAddr, ":")
+	if port, err := strconv.Atoi(s[1]); err == nil {
+		srv2.localnode.Set(enr.TCP(uint16(port)))
+	}
+	start := time.Now()
+	if !syncAddPeer(srv1, srv2.Self()) {
+		t.Fatal("peer not connected")
+	}
+	peer := srv1.Peers()[0]
+	if at := peer.ConnectedAt(); at.Before(start) || at.After(time.Now()) {
+		t.Errorf("wrong connection time %v", at)
+	}
+	if hd, sd := peer.HandshakeDuration(), peer.SessionDuration(); hd <= 0 || hd > sd {
+		t.Errorf("wrong handshake duration %v, session duration %v", hd, sd)
+	}
+
+	events := make(chan *PeerEvent, 10)
+	sub := srv1.SubscribeEvents(events)
+	defer sub.Unsubscribe()
+	time.Sleep(50 * time.Millisecond)
+	srv1.RemovePeer(srv2.Self())
+	for ev := range events {
+		if ev.Type != PeerEventTypeDrop {
+			continue
+		}
+		if ev.Duration < 50*time.Millisecond {
+			t.Errorf("wrong session duration in drop event: %v", ev.Duration)
+		}
+		if ev.Duration != peer.SessionDuration() {
+			t.Errorf("session duration changed after disconnect: %v != %v", ev.Duration, peer.SessionDuration())
+		}
+		if ev.Reason != DiscRequested.String() {
+			t.Errorf("wrong reason %q", ev.Reason)
+		}
+		break
+	}
+}
+
+// This test checks the capabilities and the direction reported in peer add events.
+func TestServerPeerAddEvent(t *testing.T) {
+	proto := Protocol{
+		Name:    "a",
+		Version: 1,
+		Length:  1,
+		Run: func(p *Peer, rw MsgReadWriter) error {
+			<-p.closed
+			return nil
+		},
+	}
+	newServer := func(name string, listen bool) *Server {
+		srv := &Server{Config: Config{
+			PrivateKey:  newkey(),
+			MaxPeers:    1,
+			NoDiscovery: true,
+			Protocols:   []Protocol{proto},
+			Logger:      testlog.Logger(t, log.LvlTrace).New("server", name),
+		}}
+		if listen {
+			srv.NoDial = true
+			srv.ListenAddr = "127.0.0.1:0"
+		}
+		if err := srv.Start(); err != nil {
+			t.Fatalf("could not start: %v", err)
+		}
+		return srv
+	}
+	srv1 := newServer("1", false)
+	defer srv1.Stop()
+	srv2 := newServer("2", true)
+	defer srv2.Stop()
+
+	s := strings.Split(srv2.ListenAddr, ":")
+	if port, err := strconv.Atoi(s[1]); err == nil {
+		srv2.localnode.Set(enr.TCP(uint16(port)))
+	}
+	events1 := make(chan *PeerEvent, 10)
+	sub1 := srv1.SubscribeEvents(events1)
+	defer sub1.Unsubscribe()
+	events2 := make(chan *PeerEvent, 10)
+	sub2 := srv2.SubscribeEvents(events2)
+	defer sub2.Unsubscribe()
+
+	srv1.AddPeer(srv2.Self())
+	for _, test := range []struct {
+		events  chan *PeerEvent
+		peer    enode.ID
+		inbound bool
+	}{
+		{events1, srv2.Self().ID(), false},
+		{events2, srv1.Self().ID(), true},
+	} {
+		select {
+		case ev := <-test.events:
+			if ev.Type != PeerEventTypeAdd || ev.Peer != test.peer {
+				t.Fatalf("unexpected event %+v", ev)
+			}
+			if want := []string{"a/1"}; !reflect.DeepEqual(ev.Caps, want) {
+				t.Errorf("wrong caps %v, want %v", ev.Caps, want)
+			}
+			if ev.Inbound != test.inbound {
+				t.Errorf("wrong direction: inbound %t, want %t", ev.Inbound, test.inbound)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("peer add event not received")
+		}
+	}
+}
+
 // This test checks that connections are disconnected just after the encryption handshake
 // when the server is at capacity. Trusted connections should still be accepted.
 func TestServerAtCap(t *testing.T) {
@@ -375,6 +592,56 @@ func TestServerPeerLimits(t *testing.T) {
 	conn.Close()
 }
 
+// This test checks the per-IP and per-subnet limits of inbound connections.
+func TestServerInboundIPLimits(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+	srv := &Server{
+		Config: Config{
+			MaxPeers:          10,
+			MaxPeersPerIP:     2,
+			MaxPeersPerSubnet: 3,
+		},
+		localnode: enode.NewLocalNode(db, newkey()),
+	}
+	var (
+		peers   = make(map[enode.ID]*Peer)
+		inbound int
+		nextID  uint16
+	)
+	newConn := func(ip string, flags connFlag) *conn {
+		nextID++
+		fd := &fakeAddrConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 30303}}
+		return &conn{fd: fd, node: newNode(uintID(nextID), ""), flags: flags}
+	}
+	check := func(ip string, flags connFlag, want error) {
+		t.Helper()
+		c := newConn(ip, flags)
+		err := srv.postHandshakeChecks(peers, inbound, c)
+		if err != want {
+			t.Fatalf("%v connection from %s: got %v, want %v", flags, ip, err, want)
+		}
+		if err == nil {
+			peers[c.node.ID()] = newPeer(log.Root(), c, nil)
+			if flags&inboundConn != 0 {
+				inbound++
+			}
+		}
+	}
+
+	check("1.2.3.4", inboundConn, nil)
+	check("1.2.3.4", inboundConn, nil)
+	check("1.2.3.4", inboundConn, DiscTooManyPeers)
+	check("1.2.3.4", inboundConn|trustedConn, nil)
+	check("1.2.3.4", dynDialedConn, nil)
+	check("1.2.3.5", inboundConn, DiscTooManyPeers) // subnet full
+	check("1.2.4.1", inboundConn, nil)
+	check("2001:db8::1", inboundConn, nil)
+	check("2001:db8::1", inboundConn, nil)
+	check("2001:db8::1", inboundConn, DiscTooManyPeers)
+	check("2001:db8::2", inboundConn, nil)
+}
+
 func TestServerSetupConn(t *testing.T) {
 	var (
 		clientkey, srvkey = newkey(), newkey()
@@ -451,6 +718,10 @@ func TestServerSetupConn(t *testing.T) {
 				}
 				defer srv.Stop()
 			}
+			events := make(chan *PeerEvent, 1)
+			sub := srv.SubscribeEvents(events)
+			defer sub.Unsubscribe()
+
 			p1, _ := net.Pipe()
 			srv.SetupConn(p1, test.flags, test.dialDest)
 			if !errors.Is(test.tt.closeErr, test.wantCloseErr) {
@@ -459,6 +730,18 @@ func TestServerSetupConn(t *testing.T) {
 			if test.tt.calls != test.wantCalls {
 				t.Errorf("test %d: calls mismatch: got %q, want %q", i, test.tt.calls, test.wantCalls)
 			}
+			select {
+			case ev := <-events:
+				if test.dontstart {
+					t.Errorf("test %d: unexpected event %v for stopped server", i, ev.Type)
+				} else if ev.Type != PeerEventTypeHandshakeFailed || ev.Error != test.tt.closeErr.Error() {
+					t.Errorf("test %d: wrong event: got %v %q, want %v %q", i, ev.Type, ev.Error, PeerEventTypeHandshakeFailed, test.tt.closeErr)
+				}
+			default:
+				if !test.dontstart {
+					t.Errorf("test %d: no handshake failure event", i)
+				}
+			}
 		})
 	}
 }
@@ -490,6 +773,8 @@ func (c *setupTransport) close(err error) {
 	c.closeErr = err
 }
 
+func (c *setupTransport) setMaxMsgSize(func(uint64) uint32) {}
+
 // setupConn shouldn't write to/read from the connection.
 func (c *setupTransport) WriteMsg(Msg) error {
 	panic("WriteMsg called on setupTransport")
@@ -579,6 +864,46 @@ func TestServerInboundThrottle(t *testing.T) {
 	}
 }
 
+func TestServerTimeoutConfig(t *testing.T) {
+	tests := []struct {
+		ping, read, handshake time.Duration
+		ok                    bool
+	}{
+		{ok: true},
+		{ping: 5 * time.Second, read: 10 * time.Second, ok: true},
+		{read: 60 * time.Second, ok: true},
+		{handshake: time.Second, ok: true},
+		{ping: minPingInterval / 2},
+		{ping: 10 * time.Second, read: 10 * time.Second},
+		{read: defaultPingInterval / 2},
+		{handshake: -time.Second},
+	}
+	for i, test := range tests {
+		srv := &Server{
+			Config: Config{
+				PrivateKey:       newkey(),
+				MaxPeers:         10,
+				NoDial:           true,
+				NoDiscovery:      true,
+				PingInterval:     test.ping,
+				ReadTimeout:      test.read,
+				HandshakeTimeout: test.handshake,
+				Logger:           testlog.Logger(t, log.LvlTrace),
+			},
+		}
+		err := srv.Start()
+		if err == nil {
+			srv.Stop()
+		}
+		if test.ok && err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("test %d: expected error for ping interval %v, read timeout %v, handshake timeout %v", i, test.ping, test.read, test.handshake)
+		}
+	}
+}
+
 func listenFakeAddr(network, laddr string, remoteAddr net.Addr) (net.Listener, error) {
 	l, err := net.Listen(network, laddr)
 	if err == nil {
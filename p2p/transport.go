@@ -34,9 +34,9 @@ import (
 )
 
 const (
-	// total timeout for encryption handshake and protocol
-	// handshake in both directions.
-	handshakeTimeout = 5 * time.Second
+	// default timeout for the encryption handshake and the protocol
+	// handshake. It applies to each handshake in both directions.
+	defaultHandshakeTimeout = 5 * time.Second
 
 	// This is the timeout for sending the disconnect reason.
 	// This is shorter than the usual timeout because we don't want
@@ -47,22 +47,35 @@ const (
 // rlpxTransport is the transport used by actual (non-test) connections.
 // It wraps an RLPx connection with locks and read/write deadlines.
 type rlpxTransport struct {
-	rmu, wmu sync.Mutex
-	wbuf     bytes.Buffer
-	conn     *rlpx.Conn
+	rmu, wmu         sync.Mutex
+	wbuf             bytes.Buffer
+	conn             *rlpx.Conn
+	readTimeout      time.Duration
+	handshakeTimeout time.Duration
 }
 
 func newRLPX(conn net.Conn, dialDest *ecdsa.PublicKey) transport {
-	return &rlpxTransport{conn: rlpx.NewConn(conn, dialDest)}
+	return &rlpxTransport{
+		conn:             rlpx.NewConn(conn, dialDest),
+		readTimeout:      frameReadTimeout,
+		handshakeTimeout: defaultHandshakeTimeout,
+	}
 }
 
 func (t *rlpxTransport) ReadMsg() (Msg, error) {
+	return t.readMsg(time.Now().Add(t.readTimeout))
+}
+
+func (t *rlpxTransport) readMsg(deadline time.Time) (Msg, error) {
 	t.rmu.Lock()
 	defer t.rmu.Unlock()
 
 	var msg Msg
-	t.conn.SetReadDeadline(time.Now().Add(frameReadTimeout))
+	t.conn.SetReadDeadline(deadline)
 	code, data, wireSize, err := t.conn.Read()
+	if errors.Is(err, rlpx.ErrMessageTooLarge) {
+		return msg, newPeerError(errInvalidMsg, "%v", err)
+	}
 	if err == nil {
 		// Protocol messages are dispatched to subprotocol handlers asynchronously,
 		// but package rlpx may reuse the returned 'data' buffer on the next call
@@ -79,7 +92,18 @@ func (t *rlpxTransport) ReadMsg() (Msg, error) {
 	return msg, err
 }
 
+func (t *rlpxTransport) setMaxMsgSize(limit func(code uint64) uint32) {
+	t.rmu.Lock()
+	defer t.rmu.Unlock()
+
+	t.conn.SetSizeLimit(func(code uint64) int { return int(limit(code)) })
+}
+
 func (t *rlpxTransport) WriteMsg(msg Msg) error {
+	return t.writeMsg(msg, time.Now().Add(frameWriteTimeout))
+}
+
+func (t *rlpxTransport) writeMsg(msg Msg, deadline time.Time) error {
 	t.wmu.Lock()
 	defer t.wmu.Unlock()
 
@@ -90,7 +114,7 @@ func (t *rlpxTransport) WriteMsg(msg Msg) error {
 	}
 
 	// Write the message.
-	t.conn.SetWriteDeadline(time.Now().Add(frameWriteTimeout))
+	t.conn.SetWriteDeadline(deadline)
 	size, err := t.conn.Write(msg.Code, t.wbuf.Bytes())
 	if err != nil {
 		return err
@@ -128,22 +152,35 @@ func (t *rlpxTransport) close(err error) {
 }
 
 func (t *rlpxTransport) doEncHandshake(prv *ecdsa.PrivateKey) (*ecdsa.PublicKey, error) {
-	t.conn.SetDeadline(time.Now().Add(handshakeTimeout))
-	return t.conn.Handshake(prv)
+	t.conn.SetDeadline(time.Now().Add(t.handshakeTimeout))
+	pubkey, err := t.conn.Handshake(prv)
+	if isTimeout(err) {
+		return nil, errHandshakeTimeout
+	}
+	return pubkey, err
 }
 
 func (t *rlpxTransport) doProtoHandshake(our *protoHandshake) (their *protoHandshake, err error) {
+	// Both directions of the handshake share a single deadline.
+	rw := &handshakeRW{t, time.Now().Add(t.handshakeTimeout)}
+
 	// Writing our handshake happens concurrently, we prefer
 	// returning the handshake read error. If the remote side
 	// disconnects us early with a valid reason, we should return it
 	// as the error so it can be tracked elsewhere.
 	werr := make(chan error, 1)
-	go func() { werr <- Send(t, handshakeMsg, our) }()
-	if their, err = readProtocolHandshake(t); err != nil {
+	go func() { werr <- Send(rw, handshakeMsg, our) }()
+	if their, err = readProtocolHandshake(rw); err != nil {
 		<-werr // make sure the write terminates too
+		if isTimeout(err) {
+			return nil, errHandshakeTimeout
+		}
 		return nil, err
 	}
 	if err := <-werr; err != nil {
+		if isTimeout(err) {
+			return nil, errHandshakeTimeout
+		}
 		return nil, fmt.Errorf("write error: %v", err)
 	}
 	// If the protocol version supports Snappy encoding, upgrade immediately
@@ -152,6 +189,27 @@ func (t *rlpxTransport) doProtoHandshake(our *protoHandshake) (their *protoHands
 	return their, nil
 }
 
+// handshakeRW reads and writes messages on an RLPx transport with a fixed
+// deadline. It is used for the protocol handshake.
+type handshakeRW struct {
+	t        *rlpxTransport
+	deadline time.Time
+}
+
+func (rw *handshakeRW) ReadMsg() (Msg, error) {
+	return rw.t.readMsg(rw.deadline)
+}
+
+func (rw *handshakeRW) WriteMsg(msg Msg) error {
+	return rw.t.writeMsg(msg, rw.deadline)
+}
+
+// isTimeout reports whether err is a network timeout.
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
 func readProtocolHandshake(rw MsgReader) (*protoHandshake, error) {
 	msg, err := rw.ReadMsg()
 	if err != nil {
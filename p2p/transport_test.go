@@ -21,6 +21,7 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -146,3 +147,57 @@ func TestProtocolHandshakeErrors(t *testing.T) {
 		}
 	}
 }
+
+// This test checks that the handshakes are aborted when the remote side does not
+// respond within the handshake timeout.
+func TestHandshakeTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	var (
+		prv0, _ = crypto.GenerateKey()
+		prv1, _ = crypto.GenerateKey()
+		pub0    = crypto.FromECDSAPub(&prv0.PublicKey)[1:]
+	)
+	check := func(phase string, f func() error) {
+		start := time.Now()
+		err := f()
+		if !errors.Is(err, errHandshakeTimeout) {
+			t.Errorf("%s handshake: wrong error %v, want %v", phase, err, errHandshakeTimeout)
+		}
+		if elapsed := time.Since(start); elapsed > 5*timeout {
+			t.Errorf("%s handshake: took %v, timeout is %v", phase, elapsed, timeout)
+		}
+	}
+
+	// Remote side never speaks.
+	fd0, fd1, err := pipes.TCPPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd1.Close()
+	dialer := newRLPX(fd0, &prv1.PublicKey).(*rlpxTransport)
+	dialer.handshakeTimeout = timeout
+	check("encryption", func() error {
+		_, err := dialer.doEncHandshake(prv0)
+		return err
+	})
+	dialer.close(nil)
+
+	// Remote side completes the encryption handshake, but never sends its
+	// protocol handshake.
+	fd0, fd1, err = pipes.TCPPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd1.Close()
+	go newRLPX(fd1, nil).doEncHandshake(prv1)
+	dialer = newRLPX(fd0, &prv1.PublicKey).(*rlpxTransport)
+	dialer.handshakeTimeout = timeout
+	if _, err := dialer.doEncHandshake(prv0); err != nil {
+		t.Fatal("encryption handshake failed:", err)
+	}
+	check("protocol", func() error {
+		_, err := dialer.doProtoHandshake(&protoHandshake{Version: baseProtocolVersion, ID: pub0})
+		return err
+	})
+	dialer.close(nil)
+}
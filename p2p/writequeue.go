@@ -0,0 +1,98 @@
+// Copyright 2026 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import "sync"
+
+// maxQueuedWrites is the maximum number of subprotocol writes which may wait
+// for their turn on a single peer.
+const maxQueuedWrites = 256
+
+// writeQueue schedules the writes of a peer in two lanes. The control lane
+// carries base protocol messages such as ping and pong, the bulk lane carries
+// subprotocol messages. Queued bulk writes do not start while control writes
+// are pending, so a control message waits for at most one bulk message.
+//
+// RLPx sends every message in a single frame, so a bulk message cannot be
+// preempted once it is being written.
+type writeQueue struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	ctrlPending int // number of control writes in progress
+	bulkQueued  int // number of bulk writes queued or in progress
+	maxBulk     int
+}
+
+func newWriteQueue(maxBulk int) *writeQueue {
+	q := &writeQueue{maxBulk: maxBulk}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// control performs a write in the control lane.
+func (q *writeQueue) control(write func() error) error {
+	q.mu.Lock()
+	q.ctrlPending++
+	q.mu.Unlock()
+
+	err := write()
+
+	q.mu.Lock()
+	q.ctrlPending--
+	if q.ctrlPending == 0 {
+		q.cond.Broadcast()
+	}
+	q.mu.Unlock()
+	return err
+}
+
+// enqueueBulk reserves a slot in the bulk lane. It returns ErrWriteQueueFull if
+// the lane is full. The slot must be released with dequeueBulk.
+func (q *writeQueue) enqueueBulk() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.bulkQueued >= q.maxBulk {
+		return ErrWriteQueueFull
+	}
+	q.bulkQueued++
+	return nil
+}
+
+// dequeueBulk releases a slot in the bulk lane.
+func (q *writeQueue) dequeueBulk() {
+	q.mu.Lock()
+	q.bulkQueued--
+	q.mu.Unlock()
+}
+
+// bulkPending returns the number of bulk writes which are queued or in progress.
+func (q *writeQueue) bulkPending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bulkQueued
+}
+
+// waitControl blocks until no control writes are pending. It is called by bulk
+// writers right before writing.
+func (q *writeQueue) waitControl() {
+	q.mu.Lock()
+	for q.ctrlPending > 0 {
+		q.cond.Wait()
+	}
+	q.mu.Unlock()
+}
@@ -0,0 +1,123 @@
+// Copyright 2026 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteQueueBulkLimit(t *testing.T) {
+	q := newWriteQueue(2)
+	for i := 0; i < 2; i++ {
+		if err := q.enqueueBulk(); err != nil {
+			t.Fatalf("enqueue %d failed: %v", i, err)
+		}
+	}
+	if err := q.enqueueBulk(); err != ErrWriteQueueFull {
+		t.Fatalf("wrong error for full lane: %v", err)
+	}
+	q.dequeueBulk()
+	if err := q.enqueueBulk(); err != nil {
+		t.Fatalf("enqueue after dequeue failed: %v", err)
+	}
+}
+
+func TestWriteQueueControlFirst(t *testing.T) {
+	var (
+		q       = newWriteQueue(1)
+		started = make(chan struct{})
+		release = make(chan struct{})
+		bulk    = make(chan struct{})
+	)
+	go q.control(func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	go func() {
+		q.waitControl()
+		close(bulk)
+	}()
+
+	select {
+	case <-bulk:
+		t.Fatal("bulk write started while control write is pending")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-bulk:
+	case <-time.After(time.Second):
+		t.Fatal("bulk write not started after control write")
+	}
+}
+
+// This benchmark measures the ping round-trip time of a peer with and without
+// a subprotocol streaming large messages at the same time.
+func BenchmarkPeerPingRTT(b *testing.B) {
+	for _, streaming := range []bool{false, true} {
+		name := "idle"
+		if streaming {
+			name = "streaming"
+		}
+		b.Run(name, func(b *testing.B) {
+			benchmarkPeerPingRTT(b, streaming)
+		})
+	}
+}
+
+func benchmarkPeerPingRTT(b *testing.B, streaming bool) {
+	const pingInterval = 10 * time.Millisecond
+	proto := Protocol{
+		Name:   "bulk",
+		Length: 1,
+		Run: func(p *Peer, rw MsgReadWriter) error {
+			data := make([]byte, 256*1024)
+			for streaming {
+				if err := Send(rw, 0, data); err != nil {
+					return err
+				}
+			}
+			<-p.closed
+			return nil
+		},
+	}
+	closer, rw, peer, _ := testPeerWithTimeouts([]Protocol{proto}, pingInterval, frameReadTimeout)
+	defer closer()
+
+	// Remote side: answer pings and discard everything else.
+	go func() {
+		for {
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				return
+			}
+			msg.Discard()
+			if msg.Code == pingMsg {
+				SendItems(rw, pongMsg)
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		time.Sleep(pingInterval)
+	}
+	b.ReportMetric(float64(peer.RTT().Microseconds()), "rtt-µs")
+}